@@ -22,18 +22,13 @@ type AI interface {
 
 	Chatbot
 	ChatSession() ChatSession
+	// CountTokens returns the number of input tokens parts take as one user message,
+	// without the system instruction and functions. It does not go through the limits.
+	CountTokens(context.Context, ...Part) (int64, error)
 
 	Close() error
 }
 
-type Schema struct {
-	Type       string         `json:"type"`
-	Properties map[string]any `json:"properties,omitempty"`
-	Enum       []string       `json:"enum,omitempty"`
-	Items      *Schema        `json:"items,omitempty"`
-	Required   []string       `json:"required,omitempty"`
-}
-
 type JSONSchema struct {
 	Name        string
 	Description string
@@ -70,7 +65,72 @@ const (
 	FunctionCallingNone
 )
 
+var _ encoding.TextUnmarshaler = new(ThinkingEffort)
+
+type ThinkingEffort int
+
+func (e *ThinkingEffort) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "low":
+		*e = ThinkingLow
+	case "medium":
+		*e = ThinkingMedium
+	case "high":
+		*e = ThinkingHigh
+	default:
+		*e = 0
+	}
+	return nil
+}
+
+const (
+	ThinkingLow ThinkingEffort = iota + 1
+	ThinkingMedium
+	ThinkingHigh
+)
+
+// ThinkingConfig controls how much a model reasons before answering.
+// Backends taking a token budget derive it from Effort when Budget is zero,
+// and backends taking an effort level derive it from Budget when Effort is zero.
+type ThinkingConfig struct {
+	Budget int64
+	Effort ThinkingEffort
+}
+
+// BudgetTokens returns the thinking token budget of the config.
+func (c ThinkingConfig) BudgetTokens() int64 {
+	if c.Budget > 0 {
+		return c.Budget
+	}
+	switch c.Effort {
+	case ThinkingLow:
+		return 2048
+	case ThinkingHigh:
+		return 32000
+	default:
+		return 10000
+	}
+}
+
+// EffortLevel returns the thinking effort level of the config.
+func (c ThinkingConfig) EffortLevel() ThinkingEffort {
+	if c.Effort != 0 {
+		return c.Effort
+	}
+	switch {
+	case c.Budget <= 0:
+		return ThinkingMedium
+	case c.Budget <= 4096:
+		return ThinkingLow
+	case c.Budget <= 16384:
+		return ThinkingMedium
+	default:
+		return ThinkingHigh
+	}
+}
+
 type Model interface {
+	SetSystemInstruction(...Part)
 	SetFunctionCall([]Function, FunctionCallingMode)
 	SetCount(x int64)
 	SetMaxTokens(x int64)
@@ -78,6 +138,7 @@ type Model interface {
 	SetTopP(x float64)
 	SetJSONResponse(set bool, schema *JSONSchema)
 	SetThinking(set bool)
+	SetThinkingConfig(ThinkingConfig)
 }
 
 type Chatbot interface {
@@ -88,6 +149,8 @@ type Chatbot interface {
 type ChatSession interface {
 	Chatbot
 	History() []Content
+	// SetHistory replaces the session history, e.g. to resume a saved conversation.
+	SetHistory([]Content) error
 }
 
 type ChatStream interface {
@@ -95,10 +158,30 @@ type ChatStream interface {
 	Close() error
 }
 
+// TokenCount is the token usage of a request. Cached, CacheCreation and ToolUse are parts of Prompt,
+// and Reasoning is part of Result. Backends which do not report a part leave it zero.
 type TokenCount struct {
 	Prompt int64
 	Result int64
 	Total  int64
+
+	Cached        int64 // prompt tokens read from the cache
+	CacheCreation int64 // prompt tokens written to the cache
+	ToolUse       int64 // prompt tokens of tool results, such as search results
+	Reasoning     int64 // result tokens spent on thinking
+}
+
+// Add returns the sum of c and other.
+func (c TokenCount) Add(other TokenCount) TokenCount {
+	return TokenCount{
+		Prompt:        c.Prompt + other.Prompt,
+		Result:        c.Result + other.Result,
+		Total:         c.Total + other.Total,
+		Cached:        c.Cached + other.Cached,
+		CacheCreation: c.CacheCreation + other.CacheCreation,
+		ToolUse:       c.ToolUse + other.ToolUse,
+		Reasoning:     c.Reasoning + other.Reasoning,
+	}
 }
 
 type ChatResponse interface {
@@ -5,23 +5,112 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/sunshineplan/ai"
+	"github.com/sunshineplan/ai/aitest"
 	"github.com/sunshineplan/ai/anthropic"
 	"github.com/sunshineplan/ai/chatgpt"
 	"github.com/sunshineplan/ai/gemini"
+	"github.com/sunshineplan/ai/recorder"
 )
 
-func sleep() {
-	time.Sleep(30 * time.Second)
+// sleep waits between calls to a live API to stay within its rate limits.
+func sleep(offline bool) {
+	if !offline {
+		time.Sleep(30 * time.Second)
+	}
 }
 
-func testChat(model string, c ai.AI, prompt string) error {
+// mockReplies scripts the replies of a mock server to the calls made by a provider test.
+// Anthropic answers JSON requests by calling the synthetic JSON tool.
+func mockReplies(anthropic bool) []aitest.Reply {
+	colors := `[{"name":"Red","RGB":"#FF0000"},{"name":"Yellow","RGB":"#FFFF00"},{"name":"Blue","RGB":"#0000FF"}]`
+	jsonPlain, jsonSchema := aitest.Text(`{"primary_colors":["red","yellow","blue"]}`), aitest.Text(colors)
+	if anthropic {
+		jsonPlain = aitest.Reply{FunctionCalls: []ai.FunctionCall{{ID: "toolu_1", Name: "json_response", Arguments: `{"primary_colors":["red","yellow","blue"]}`}}}
+		jsonSchema = aitest.Reply{FunctionCalls: []ai.FunctionCall{{ID: "toolu_2", Name: "color_list", Arguments: `{"response":` + colors + `}`}}}
+	}
+	return []aitest.Reply{
+		aitest.Text("Hello! How can I help you today?"),
+		{Chunks: []aitest.Reply{aitest.Text("I don't know who you are,"), aitest.Text(" as you haven't told me.")}},
+		aitest.Text("Two dogs must keep your house lively."),
+		{Chunks: []aitest.Reply{aitest.Text("Each dog has 4 paws,"), aitest.Text(" so there are 8 paws in your house.")}},
+		jsonPlain,
+		jsonSchema,
+		aitest.Text("A man working on a laptop computer."),
+		aitest.Text("I don't have access to showtimes."),
+		{Chunks: []aitest.Reply{{FunctionCalls: []ai.FunctionCall{{ID: "call_1", Name: "find_theaters", Arguments: `{"location":"Mountain View, CA","title":"Barbie"}`}}}}},
+		{Chunks: []aitest.Reply{aitest.Text("Barbie is playing at the"), aitest.Text(" AMC16 theater.")}},
+		{Chunks: []aitest.Reply{aitest.Text("I can't look up showtimes right now.")}},
+	}
+}
+
+// setup returns the API key, client options and settings of a provider read from
+// environment variables starting with prefix.
+// With AI_RECORD set, the interactions are recorded to testdata/cassettes.
+// Without an API key, they are replayed from there, or if none is recorded, the test
+// runs against a mock server created by newServer, which only checks the client
+// against the aitest servers. Both cases are reported by offline.
+func setup(t *testing.T, prefix string, newServer func(...aitest.Reply) *aitest.Server) (apiKey string, opts []ai.ClientOption, getenv func(string) string, offline bool) {
+	t.Helper()
+	path := filepath.Join("testdata", "cassettes", strings.ToLower(prefix)+".json")
+	apiKey, getenv = os.Getenv(prefix+"_API_KEY"), os.Getenv
+	if apiKey == "" {
+		rec, err := recorder.New(path, recorder.Replay, nil)
+		if os.IsNotExist(err) {
+			s := newServer(mockReplies(prefix == "ANTHROPIC")...)
+			t.Cleanup(s.Close)
+			getenv = func(key string) string {
+				if strings.HasPrefix(key, prefix+"_MODEL") {
+					return "test"
+				}
+				return ""
+			}
+			return "test", []ai.ClientOption{ai.WithEndpoint(s.URL)}, getenv, true
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return "test", []ai.ClientOption{ai.WithEndpoint(rec.Value(prefix + "_ENDPOINT")), ai.WithTransport(rec)}, rec.Value, true
+	}
+	opts = []ai.ClientOption{ai.WithEndpoint(os.Getenv(prefix + "_ENDPOINT")), ai.WithProxy(os.Getenv(prefix + "_PROXY"))}
+	if os.Getenv("AI_RECORD") == "" {
+		return
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy := os.Getenv(prefix + "_PROXY"); proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	rec, err := recorder.New(path, recorder.Record, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Scrub(apiKey)
+	t.Cleanup(func() {
+		if err := rec.Save(); err != nil {
+			t.Error(err)
+		}
+	})
+	getenv = func(key string) string {
+		value := os.Getenv(key)
+		rec.SetValue(key, value)
+		return value
+	}
+	return apiKey, []ai.ClientOption{ai.WithEndpoint(getenv(prefix + "_ENDPOINT")), ai.WithTransport(rec)}, getenv, false
+}
+
+func testChat(model string, c ai.AI, prompt string, offline bool) error {
 	if model == "" {
 		return nil
 	} else {
@@ -37,11 +126,11 @@ func testChat(model string, c ai.AI, prompt string) error {
 	fmt.Println(resp)
 	fmt.Println(resp.TokenCount())
 	fmt.Println("---")
-	sleep()
+	sleep(offline)
 	return nil
 }
 
-func testChatStream(model string, c ai.AI, prompt string) error {
+func testChatStream(model string, c ai.AI, prompt string, offline bool) error {
 	if model == "" {
 		return nil
 	} else {
@@ -67,11 +156,11 @@ func testChatStream(model string, c ai.AI, prompt string) error {
 		fmt.Println(resp.TokenCount())
 	}
 	fmt.Println("---")
-	sleep()
+	sleep(offline)
 	return nil
 }
 
-func testChatSession(model string, c ai.AI) error {
+func testChatSession(model string, c ai.AI, offline bool) error {
 	if model == "" {
 		return nil
 	} else {
@@ -118,11 +207,11 @@ func testChatSession(model string, c ai.AI) error {
 		}
 	}
 	fmt.Println("---")
-	sleep()
+	sleep(offline)
 	return nil
 }
 
-func testImage(t *testing.T, model string, c ai.AI) {
+func testImage(t *testing.T, model string, c ai.AI, offline bool) {
 	if model == "" {
 		return
 	} else {
@@ -136,11 +225,11 @@ func testImage(t *testing.T, model string, c ai.AI) {
 		}
 		fmt.Println(resp)
 		checkMatch(t, resp.Results()[0], "man|person", "computer|laptop")
-		sleep()
+		sleep(offline)
 	}
 }
 
-func testJSON(t *testing.T, model string, c ai.AI) {
+func testJSON(t *testing.T, model string, c ai.AI, offline bool) {
 	if model == "" {
 		return
 	} else {
@@ -163,22 +252,16 @@ func testJSON(t *testing.T, model string, c ai.AI) {
 			t.Fatal(err)
 		}
 	}
-	sleep()
+	sleep(offline)
 	c.SetJSONResponse(true, &ai.JSONSchema{
 		Name: "color list",
 		Schema: ai.Schema{
 			Type: "array",
 			Items: &ai.Schema{
 				Type: "object",
-				Properties: map[string]any{
-					"name": map[string]string{
-						"type":        "string",
-						"description": "The name of the color",
-					},
-					"RGB": map[string]string{
-						"type":        "string",
-						"description": "The RGB value of the color, in hex",
-					},
+				Properties: map[string]*ai.Schema{
+					"name": {Type: "string", Description: "The name of the color"},
+					"RGB":  {Type: "string", Description: "The RGB value of the color, in hex"},
 				},
 				Required: []string{"name", "RGB"},
 			},
@@ -203,7 +286,7 @@ func testJSON(t *testing.T, model string, c ai.AI) {
 	c.SetJSONResponse(false, nil)
 }
 
-func testFunctionCall(t *testing.T, model string, c ai.AI) {
+func testFunctionCall(t *testing.T, model string, c ai.AI, offline bool) {
 	if model == "" {
 		return
 	} else {
@@ -305,26 +388,23 @@ func testFunctionCall(t *testing.T, model string, c ai.AI) {
 	}
 	schema := ai.Schema{
 		Type: "object",
-		Properties: map[string]any{
-			"location": map[string]any{
-				"type":        "string",
-				"description": "The city and state, e.g. San Francisco, CA or a zip code e.g. 95616",
-			},
-			"title": map[string]any{
-				"type":        "string",
-				"description": "Any movie title",
+		Properties: map[string]*ai.Schema{
+			"location": {
+				Type:        "string",
+				Description: "The city and state, e.g. San Francisco, CA or a zip code e.g. 95616",
 			},
+			"title": {Type: "string", Description: "Any movie title"},
 		},
 		Required: []string{"location"},
 	}
 	t.Run("direct", func(t *testing.T) {
 		movieChat(t, schema, ai.FunctionCallingAuto)
 	})
-	sleep()
+	sleep(offline)
 	t.Run("none", func(t *testing.T) {
 		movieChat(t, schema, ai.FunctionCallingNone)
 	})
-	sleep()
+	sleep(offline)
 }
 
 func checkMatch(t *testing.T, got string, wants ...string) {
@@ -341,90 +421,71 @@ func checkMatch(t *testing.T, got string, wants ...string) {
 }
 
 func TestGemini(t *testing.T) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return
-	}
-	gemini, err := gemini.New(
-		context.Background(),
-		ai.WithAPIKey(apiKey),
-		ai.WithEndpoint(os.Getenv("GEMINI_ENDPOINT")),
-		ai.WithProxy(os.Getenv("GEMINI_PROXY")),
-	)
+	apiKey, opts, getenv, offline := setup(t, "GEMINI", aitest.NewGeminiServer)
+	gemini, err := gemini.New(context.Background(), append(opts, ai.WithAPIKey(apiKey))...)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer gemini.Close()
-	model := os.Getenv("GEMINI_MODEL")
-	if err := testChat(model, gemini, "Hello!"); err != nil {
+	model := getenv("GEMINI_MODEL")
+	if err := testChat(model, gemini, "Hello!", offline); err != nil {
 		t.Error(err)
 	}
-	if err := testChatStream(model, gemini, "Who am I?"); err != nil {
+	if err := testChatStream(model, gemini, "Who am I?", offline); err != nil {
 		t.Error(err)
 	}
-	if err := testChatSession(model, gemini); err != nil {
+	if err := testChatSession(model, gemini, offline); err != nil {
 		t.Error(err)
 	}
-	testJSON(t, model, gemini)
-	testImage(t, os.Getenv("GEMINI_MODEL_FOR_IMAGE"), gemini)
-	testFunctionCall(t, os.Getenv("GEMINI_MODEL_FOR_TOOLS"), gemini)
+	testJSON(t, model, gemini, offline)
+	testImage(t, getenv("GEMINI_MODEL_FOR_IMAGE"), gemini, offline)
+	testFunctionCall(t, getenv("GEMINI_MODEL_FOR_TOOLS"), gemini, offline)
 }
 
 func TestChatGPT(t *testing.T) {
-	apiKey := os.Getenv("CHATGPT_API_KEY")
-	if apiKey == "" {
-		return
-	}
-	chatgpt, err := chatgpt.New(
-		ai.WithAPIKey(apiKey),
-		ai.WithEndpoint(os.Getenv("CHATGPT_ENDPOINT")),
-		ai.WithProxy(os.Getenv("CHATGPT_PROXY")),
-	)
+	apiKey, opts, getenv, offline := setup(t, "CHATGPT", aitest.NewOpenAIServer)
+	chatgpt, err := chatgpt.New(append(opts, ai.WithAPIKey(apiKey))...)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer chatgpt.Close()
-	model := os.Getenv("CHATGPT_MODEL")
-	if err := testChat(model, chatgpt, "Who are you?"); err != nil {
+	model := getenv("CHATGPT_MODEL")
+	if err := testChat(model, chatgpt, "Who are you?", offline); err != nil {
 		t.Error(err)
 	}
-	if err := testChatStream(model, chatgpt, "Who am I?"); err != nil {
+	if err := testChatStream(model, chatgpt, "Who am I?", offline); err != nil {
 		t.Error(err)
 	}
-	if err := testChatSession(model, chatgpt); err != nil {
+	if err := testChatSession(model, chatgpt, offline); err != nil {
 		t.Error(err)
 	}
-	testJSON(t, model, chatgpt)
-	testImage(t, os.Getenv("CHATGPT_MODEL_FOR_IMAGE"), chatgpt)
-	testFunctionCall(t, os.Getenv("CHATGPT_MODEL_FOR_TOOLS"), chatgpt)
+	testJSON(t, model, chatgpt, offline)
+	testImage(t, getenv("CHATGPT_MODEL_FOR_IMAGE"), chatgpt, offline)
+	testFunctionCall(t, getenv("CHATGPT_MODEL_FOR_TOOLS"), chatgpt, offline)
 }
 
 func TestAnthropic(t *testing.T) {
-	apiKey := os.Getenv("ANTHROPIC_API_KEY")
-	if apiKey == "" {
-		return
-	}
-	anthropic, err := anthropic.New(
-		ai.WithAPIKey(apiKey),
-		ai.WithEndpoint(os.Getenv("ANTHROPIC_ENDPOINT")),
-		ai.WithProxy(os.Getenv("ANTHROPIC_PROXY")),
-	)
+	apiKey, opts, getenv, offline := setup(t, "ANTHROPIC", aitest.NewAnthropicServer)
+	anthropic, err := anthropic.New(append(opts, ai.WithAPIKey(apiKey))...)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer anthropic.Close()
-	model := os.Getenv("ANTHROPIC_MODEL")
-	if err := testChat(model, anthropic, "Who are you?"); err != nil {
+	// Non-streaming requests with the default max tokens may take longer than the SDK allows.
+	anthropic.SetMaxTokens(4096)
+	model := getenv("ANTHROPIC_MODEL")
+	if err := testChat(model, anthropic, "Who are you?", offline); err != nil {
 		t.Fatal(err)
 	}
-	if err := testChatStream(model, anthropic, "Who am I?"); err != nil {
+	if err := testChatStream(model, anthropic, "Who am I?", offline); err != nil {
 		t.Error(err)
 	}
-	if err := testChatSession(model, anthropic); err != nil {
+	if err := testChatSession(model, anthropic, offline); err != nil {
 		t.Error(err)
 	}
-	testImage(t, os.Getenv("ANTHROPIC_MODEL_FOR_IMAGE"), anthropic)
-	testFunctionCall(t, os.Getenv("ANTHROPIC_MODEL_FOR_TOOLS"), anthropic)
+	testJSON(t, model, anthropic, offline)
+	testImage(t, getenv("ANTHROPIC_MODEL_FOR_IMAGE"), anthropic, offline)
+	testFunctionCall(t, getenv("ANTHROPIC_MODEL_FOR_TOOLS"), anthropic, offline)
 }
 
 func TestUnmarshalFunctionCallingMode(t *testing.T) {
@@ -451,3 +512,98 @@ func TestUnmarshalFunctionCallingMode(t *testing.T) {
 		t.Errorf("expected %d; got %d", 0, m)
 	}
 }
+
+func TestThinkingConfig(t *testing.T) {
+	var cfg ai.ThinkingConfig
+	if err := json.Unmarshal([]byte(`{"effort":"high"}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Effort != ai.ThinkingHigh {
+		t.Errorf("expected %d; got %d", ai.ThinkingHigh, cfg.Effort)
+	}
+	for i, tc := range []struct {
+		cfg    ai.ThinkingConfig
+		budget int64
+		effort ai.ThinkingEffort
+	}{
+		{ai.ThinkingConfig{}, 10000, ai.ThinkingMedium},
+		{ai.ThinkingConfig{Effort: ai.ThinkingLow}, 2048, ai.ThinkingLow},
+		{ai.ThinkingConfig{Budget: 1024}, 1024, ai.ThinkingLow},
+		{ai.ThinkingConfig{Budget: 50000}, 50000, ai.ThinkingHigh},
+		{ai.ThinkingConfig{Budget: 50000, Effort: ai.ThinkingLow}, 50000, ai.ThinkingLow},
+	} {
+		if budget := tc.cfg.BudgetTokens(); budget != tc.budget {
+			t.Errorf("#%d: expected budget %d; got %d", i, tc.budget, budget)
+		}
+		if effort := tc.cfg.EffortLevel(); effort != tc.effort {
+			t.Errorf("#%d: expected effort %d; got %d", i, tc.effort, effort)
+		}
+	}
+}
+
+func TestThoughtMigration(t *testing.T) {
+	call := aitest.Reply{Thoughts: []string{"Use the weather tool."}, FunctionCalls: []ai.FunctionCall{{ID: "weather", Name: "weather", Arguments: `{"city":"Paris"}`}}}
+	geminiServer := aitest.NewGeminiServer(call, aitest.Text("Sunny"))
+	defer geminiServer.Close()
+	anthropicServer := aitest.NewAnthropicServer(aitest.Text("Sunny"), call)
+	defer anthropicServer.Close()
+	gemini, err := gemini.New(t.Context(), ai.WithAPIKey("test"), ai.WithEndpoint(geminiServer.URL), ai.WithModel("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gemini.SetThinking(true)
+	anthropic, err := anthropic.New(ai.WithAPIKey("test"), ai.WithEndpoint(anthropicServer.URL), ai.WithModel("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anthropic.SetMaxTokens(4096)
+	anthropic.SetThinking(true)
+	migrate := func(from, to ai.AI) {
+		session := from.ChatSession()
+		if _, err := session.Chat(t.Context(), ai.Text("Weather in Paris?")); err != nil {
+			t.Fatal(err)
+		}
+		restored := to.ChatSession()
+		if err := restored.SetHistory(session.History()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := restored.Chat(t.Context(), ai.FunctionResponse{ID: "weather", Name: "weather", Response: `{"weather":"sunny"}`}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrate(gemini, anthropic)
+	var anthropicReq struct {
+		Messages []struct{ Content []map[string]any }
+	}
+	if err := anthropicServer.Requests()[0].JSON(&anthropicReq); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range anthropicReq.Messages {
+		for _, i := range msg.Content {
+			if i["type"] == "thinking" || i["type"] == "redacted_thinking" {
+				t.Errorf("expected no thinking blocks signed by gemini; got %v", i)
+			}
+		}
+	}
+
+	migrate(anthropic, gemini)
+	var geminiReq struct {
+		Contents []struct {
+			Parts []struct {
+				Thought          bool
+				ThoughtSignature []byte
+			}
+		}
+	}
+	if err := geminiServer.Requests()[1].JSON(&geminiReq); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range geminiReq.Contents {
+		for _, i := range content.Parts {
+			if i.Thought || len(i.ThoughtSignature) > 0 {
+				t.Errorf("expected no thoughts signed by anthropic; got %+v", i)
+			}
+		}
+	}
+}
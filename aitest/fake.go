@@ -0,0 +1,351 @@
+// Package aitest provides a scripted ai.AI for testing code built on package ai without network access.
+package aitest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/sunshineplan/ai"
+)
+
+// LLMs is the name reported by a Fake.
+const LLMs ai.LLMs = "Fake"
+
+// ErrNoReply is returned when a Fake is called with no scripted reply left.
+var ErrNoReply = errors.New("aitest: no scripted reply left")
+
+// Reply is a scripted reply of a Fake.
+type Reply struct {
+	Results       []string
+	Thoughts      []string
+	FunctionCalls []ai.FunctionCall
+	TokenCount    ai.TokenCount
+	// Err is returned by the call instead of a response, or by Next when the reply is a stream chunk.
+	Err error
+	// Chunks are the chunks a stream yields for the reply. If empty, the stream yields the reply itself.
+	Chunks []Reply
+}
+
+// Text returns a reply with the given results.
+func Text(results ...string) Reply { return Reply{Results: results} }
+
+// Error returns a reply failing with err.
+func Error(err error) Reply { return Reply{Err: err} }
+
+var _ ai.ChatResponse = new(response)
+
+type response struct{ reply Reply }
+
+// Raw returns the Reply.
+func (resp *response) Raw() any                         { return resp.reply }
+func (resp *response) Results() []string                { return resp.reply.Results }
+func (resp *response) Thoughts() []string               { return resp.reply.Thoughts }
+func (resp *response) FunctionCalls() []ai.FunctionCall { return resp.reply.FunctionCalls }
+func (resp *response) TokenCount() ai.TokenCount        { return resp.reply.TokenCount }
+
+// Call is a call recorded by a Fake.
+type Call struct {
+	Parts  []ai.Part
+	Stream bool
+	// History is the session history before the call, or nil outside sessions.
+	History []ai.Content
+	// Settings are the model settings at the time of the call.
+	Settings Settings
+}
+
+// Settings are the model settings applied to a Fake.
+type Settings struct {
+	Model               string
+	SystemInstruction   []ai.Part
+	Functions           []ai.Function
+	FunctionCallingMode ai.FunctionCallingMode
+	Count               *int64
+	MaxTokens           *int64
+	Temperature         *float64
+	TopP                *float64
+	JSONResponse        bool
+	JSONSchema          *ai.JSONSchema
+	Thinking            bool
+	ThinkingConfig      *ai.ThinkingConfig
+}
+
+var _ ai.AI = new(Fake)
+
+// Fake is an ai.AI which answers calls with scripted replies, in order, and records the calls.
+// Limits set on it are applied as by the providers. A Fake is safe for concurrent use.
+type Fake struct {
+	ai.Limits
+
+	mu       sync.Mutex
+	replies  []Reply
+	calls    []Call
+	settings Settings
+	models   []string
+	closed   bool
+}
+
+// New returns a Fake answering with replies.
+func New(replies ...Reply) *Fake {
+	return &Fake{replies: replies}
+}
+
+// Push queues more replies.
+func (f *Fake) Push(replies ...Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, replies...)
+}
+
+// Remaining returns the number of scripted replies not used yet.
+func (f *Fake) Remaining() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.replies)
+}
+
+// Calls returns the calls made so far.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// Settings returns the current model settings.
+func (f *Fake) Settings() Settings {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.settings
+}
+
+// SetModels sets the models returned by ListModels.
+func (f *Fake) SetModels(models ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.models = models
+}
+
+func (f *Fake) set(fn func(*Settings)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(&f.settings)
+}
+
+// next records a call and returns the reply to it.
+func (f *Fake) next(parts []ai.Part, stream bool, history []ai.Content) (Reply, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return Reply{}, ai.ErrAIClosed
+	}
+	f.calls = append(f.calls, Call{Parts: parts, Stream: stream, History: history, Settings: f.settings})
+	if len(f.replies) == 0 {
+		return Reply{}, ErrNoReply
+	}
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	return reply, reply.Err
+}
+
+func (*Fake) LLMs() ai.LLMs { return LLMs }
+
+func (f *Fake) Model() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.settings.Model
+}
+
+func (f *Fake) SetModel(model string) { f.set(func(s *Settings) { s.Model = model }) }
+
+func (f *Fake) ListModels(context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ai.ErrAIClosed
+	}
+	return slices.Clone(f.models), nil
+}
+
+// CountTokens returns ai.EstimateTokens of parts.
+func (f *Fake) CountTokens(_ context.Context, parts ...ai.Part) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, ai.ErrAIClosed
+	}
+	return ai.EstimateTokens(parts...), nil
+}
+
+func (f *Fake) SetSystemInstruction(parts ...ai.Part) {
+	f.set(func(s *Settings) { s.SystemInstruction = parts })
+}
+func (f *Fake) SetFunctionCall(functions []ai.Function, mode ai.FunctionCallingMode) {
+	f.set(func(s *Settings) { s.Functions, s.FunctionCallingMode = functions, mode })
+}
+func (f *Fake) SetCount(x int64)         { f.set(func(s *Settings) { s.Count = &x }) }
+func (f *Fake) SetMaxTokens(x int64)     { f.set(func(s *Settings) { s.MaxTokens = &x }) }
+func (f *Fake) SetTemperature(x float64) { f.set(func(s *Settings) { s.Temperature = &x }) }
+func (f *Fake) SetTopP(x float64)        { f.set(func(s *Settings) { s.TopP = &x }) }
+func (f *Fake) SetJSONResponse(set bool, schema *ai.JSONSchema) {
+	f.set(func(s *Settings) { s.JSONResponse, s.JSONSchema = set, schema })
+}
+func (f *Fake) SetThinking(set bool) { f.set(func(s *Settings) { s.Thinking = set }) }
+func (f *Fake) SetThinkingConfig(cfg ai.ThinkingConfig) {
+	f.set(func(s *Settings) { s.Thinking, s.ThinkingConfig = true, &cfg })
+}
+
+func (f *Fake) chat(ctx context.Context, parts []ai.Part, history []ai.Content) (ai.ChatResponse, error) {
+	release, err := f.Acquire(ctx, ai.EstimateTokens(parts...))
+	if err != nil {
+		return nil, err
+	}
+	reply, err := f.next(parts, false, history)
+	release.Done(reply.TokenCount)
+	if err != nil {
+		return nil, err
+	}
+	return &response{reply}, nil
+}
+
+func (f *Fake) chatStream(ctx context.Context, parts []ai.Part, history []ai.Content) (*stream, error) {
+	release, err := f.Acquire(ctx, ai.EstimateTokens(parts...))
+	if err != nil {
+		return nil, err
+	}
+	reply, err := f.next(parts, true, history)
+	if err != nil {
+		release.Done(ai.TokenCount{})
+		return nil, err
+	}
+	chunks := reply.Chunks
+	if len(chunks) == 0 {
+		chunks = []Reply{reply}
+	}
+	return &stream{ctx: ctx, chunks: chunks, release: release}, nil
+}
+
+func (f *Fake) Chat(ctx context.Context, parts ...ai.Part) (ai.ChatResponse, error) {
+	return f.chat(ctx, parts, nil)
+}
+
+func (f *Fake) ChatStream(ctx context.Context, parts ...ai.Part) (ai.ChatStream, error) {
+	stream, err := f.chatStream(ctx, parts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (f *Fake) ChatSession() ai.ChatSession {
+	return &session{fake: f}
+}
+
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+var _ ai.ChatStream = new(stream)
+
+type stream struct {
+	ctx     context.Context
+	chunks  []Reply
+	release ai.Release
+	usage   ai.TokenCount
+	// done is called with the chunks yielded once the stream ends successfully.
+	done func([]Reply)
+	sent []Reply
+}
+
+func (s *stream) Next() (ai.ChatResponse, error) {
+	if err := s.ctx.Err(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if len(s.chunks) == 0 {
+		if s.done != nil {
+			s.done(s.sent)
+			s.done = nil
+		}
+		s.Close()
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	if chunk.Err != nil {
+		s.Close()
+		return nil, chunk.Err
+	}
+	if chunk.TokenCount.Total > s.usage.Total {
+		s.usage = chunk.TokenCount
+	}
+	s.sent = append(s.sent, chunk)
+	return &response{chunk}, nil
+}
+
+func (s *stream) Close() error {
+	s.release.Done(s.usage)
+	s.release = nil
+	return nil
+}
+
+var _ ai.ChatSession = new(session)
+
+type session struct {
+	fake    *Fake
+	mu      sync.Mutex
+	history []ai.Content
+}
+
+func (s *session) append(parts []ai.Part, replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []ai.Part
+	for _, reply := range replies {
+		for _, i := range reply.Results {
+			res = append(res, ai.Text(i))
+		}
+		for _, i := range reply.FunctionCalls {
+			res = append(res, i)
+		}
+	}
+	s.history = append(s.history, ai.Content{Role: ai.RoleUser, Parts: parts}, ai.Content{Role: ai.RoleAssistant, Parts: res})
+}
+
+func (s *session) Chat(ctx context.Context, parts ...ai.Part) (ai.ChatResponse, error) {
+	resp, err := s.fake.chat(ctx, parts, s.History())
+	if err != nil {
+		return nil, err
+	}
+	s.append(parts, resp.Raw().(Reply))
+	return resp, nil
+}
+
+func (s *session) ChatStream(ctx context.Context, parts ...ai.Part) (ai.ChatStream, error) {
+	stream, err := s.fake.chatStream(ctx, parts, s.History())
+	if err != nil {
+		return nil, err
+	}
+	stream.done = func(chunks []Reply) { s.append(parts, chunks...) }
+	return stream, nil
+}
+
+func (s *session) History() []ai.Content {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.history == nil {
+		return nil
+	}
+	return slices.Clone(s.history)
+}
+
+func (s *session) SetHistory(history []ai.Content) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = slices.Clone(history)
+	return nil
+}
@@ -0,0 +1,78 @@
+package aitest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sunshineplan/ai"
+)
+
+func TestFake(t *testing.T) {
+	errTest := errors.New("test")
+	f := New(
+		Text("hello"),
+		Error(errTest),
+		Reply{Chunks: []Reply{Text("a"), {Results: []string{"b"}, TokenCount: ai.TokenCount{Total: 3}}}},
+		Reply{FunctionCalls: []ai.FunctionCall{{ID: "1", Name: "f"}}},
+	)
+	f.SetModel("model")
+	f.SetTemperature(0.5)
+
+	resp, err := f.Chat(context.Background(), ai.Text("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"hello"}) {
+		t.Errorf("expected [hello]; got %q", res)
+	}
+	if _, err := f.Chat(context.Background(), ai.Text("hi")); err != errTest {
+		t.Errorf("expected errTest; got %v", err)
+	}
+
+	s := f.ChatSession()
+	stream, err := s.ChatStream(context.Background(), ai.Text("stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for {
+		resp, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, resp.Results()...)
+	}
+	if !reflect.DeepEqual(res, []string{"a", "b"}) {
+		t.Errorf("expected [a b]; got %q", res)
+	}
+	if resp, err = s.Chat(context.Background(), ai.Text("call")); err != nil {
+		t.Fatal(err)
+	} else if len(resp.FunctionCalls()) != 1 {
+		t.Errorf("expected 1 function call; got %d", len(resp.FunctionCalls()))
+	}
+	if _, err := f.Chat(context.Background(), ai.Text("hi")); err != ErrNoReply {
+		t.Errorf("expected ErrNoReply; got %v", err)
+	}
+
+	if n := len(s.History()); n != 4 {
+		t.Fatalf("expected 4 contents; got %d", n)
+	}
+	if expect := []ai.Part{ai.Text("a"), ai.Text("b")}; !reflect.DeepEqual(s.History()[1].Parts, expect) {
+		t.Errorf("expected %v; got %v", expect, s.History()[1].Parts)
+	}
+	calls := f.Calls()
+	if len(calls) != 5 {
+		t.Fatalf("expected 5 calls; got %d", len(calls))
+	}
+	if c := calls[2]; !c.Stream || c.History != nil || c.Settings.Model != "model" || *c.Settings.Temperature != 0.5 {
+		t.Errorf("unexpected call %+v", c)
+	}
+	if n := len(calls[3].History); n != 2 {
+		t.Errorf("expected 2 contents in history; got %d", n)
+	}
+}
@@ -0,0 +1,678 @@
+package aitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sunshineplan/ai"
+)
+
+// StatusError is a Reply error which a Server sends as an API error with the given status code.
+// Code is the vendor specific error code, type or status, such as "insufficient_quota".
+// Other errors are sent with status 500.
+type StatusError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func statusError(err error) *StatusError {
+	if e, ok := err.(*StatusError); ok {
+		return e
+	}
+	return &StatusError{http.StatusInternalServerError, "internal_error", err.Error()}
+}
+
+// ServerRequest is a request received by a Server.
+type ServerRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// JSON decodes the request body into v.
+func (r *ServerRequest) JSON(v any) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Server is an httptest.Server speaking the wire format of a vendor API, answering chat
+// requests with scripted replies in order. Point ai.WithEndpoint at its URL to exercise
+// a provider end to end. Stream requests are answered with the Chunks of the reply as
+// server-sent events. Embedding and token counting requests take no reply, and are answered
+// with Embedding and with ai.EstimateTokens of the texts in the request.
+//
+// The vendor SDKs retry failed requests on their own unless ai.WithRetry is set,
+// with each attempt taking a reply.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	replies  []Reply
+	requests []*ServerRequest
+	models   []string
+}
+
+func newServer(handle func(s *Server, w http.ResponseWriter, r *ServerRequest), replies []Reply) *Server {
+	s := &Server{replies: replies}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := &ServerRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body}
+		if r.URL.RawQuery != "" {
+			req.Path += "?" + r.URL.RawQuery
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+		handle(s, w, req)
+	}))
+	return s
+}
+
+// Push queues more replies.
+func (s *Server) Push(replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies = append(s.replies, replies...)
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []*ServerRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// SetModels sets the models listed by the server.
+func (s *Server) SetModels(models ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = models
+}
+
+func (s *Server) listModels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.models)
+}
+
+func (s *Server) next() (Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.replies) == 0 {
+		return Reply{}, ErrNoReply
+	}
+	reply := s.replies[0]
+	s.replies = s.replies[1:]
+	return reply, reply.Err
+}
+
+func (reply Reply) chunks() []Reply {
+	if len(reply.Chunks) == 0 {
+		return []Reply{reply}
+	}
+	return reply.Chunks
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+type sseWriter struct{ w http.ResponseWriter }
+
+func newSSEWriter(w http.ResponseWriter) sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return sseWriter{w}
+}
+
+func (w sseWriter) event(event string, data any) {
+	if event != "" {
+		fmt.Fprintf(w.w, "event: %s\n", event)
+	}
+	switch v := data.(type) {
+	case string:
+		fmt.Fprintf(w.w, "data: %s\n\n", v)
+	default:
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(w.w, "data: %s\n\n", b)
+	}
+	w.w.(http.Flusher).Flush()
+}
+
+// arguments returns the arguments of a function call as a JSON object.
+func arguments(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(s)
+}
+
+type object = map[string]any
+
+// signature returns the signature the servers give to a thought, or to a function call
+// for Gemini, which providers must send back unchanged.
+func signature(s string) string {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("sig_%x", h.Sum64())
+}
+
+// countTokens returns ai.EstimateTokens of all "text" fields in a JSON body.
+func countTokens(body []byte) (n int64) {
+	var walk func(any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, i := range v {
+				if s, ok := i.(string); ok && k == "text" {
+					n += ai.EstimateTokens(ai.Text(s))
+				} else {
+					walk(i)
+				}
+			}
+		case []any:
+			for _, i := range v {
+				walk(i)
+			}
+		}
+	}
+	var v any
+	json.Unmarshal(body, &v)
+	walk(v)
+	return
+}
+
+// Embedding returns a deterministic unit vector of dimensions derived from text, which a Server
+// uses to answer embedding requests. Dimensions default to 8.
+func Embedding(text string, dimensions int) []float32 {
+	if dimensions <= 0 {
+		dimensions = 8
+	}
+	vector := make([]float32, dimensions)
+	var sum float64
+	for i := range vector {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%d:%s", i, text)
+		v := float64(h.Sum32())/math.MaxUint32*2 - 1
+		vector[i], sum = float32(v), sum+v*v
+	}
+	if sum > 0 {
+		for i := range vector {
+			vector[i] /= float32(math.Sqrt(sum))
+		}
+	}
+	return vector
+}
+
+// NewOpenAIServer returns a Server speaking the OpenAI chat completions API.
+// Use its URL as the endpoint of the chatgpt provider.
+func NewOpenAIServer(replies ...Reply) *Server {
+	return newServer(handleOpenAI, replies)
+}
+
+func openAIError(w http.ResponseWriter, err error) {
+	e := statusError(err)
+	writeJSON(w, e.StatusCode, object{"error": object{"message": e.Message, "type": e.Code, "code": e.Code}})
+}
+
+func openAIUsage(usage ai.TokenCount) object {
+	return object{
+		"prompt_tokens":             usage.Prompt,
+		"completion_tokens":         usage.Result,
+		"total_tokens":              usage.Total,
+		"prompt_tokens_details":     object{"cached_tokens": usage.Cached},
+		"completion_tokens_details": object{"reasoning_tokens": usage.Reasoning},
+	}
+}
+
+func openAIToolCalls(reply Reply, index *int) (calls []object) {
+	for _, i := range reply.FunctionCalls {
+		if i.ID != "" {
+			*index++
+		}
+		call := object{"index": max(*index, 0), "function": object{"name": i.Name, "arguments": i.Arguments}}
+		if i.ID != "" {
+			call["id"], call["type"] = i.ID, "function"
+		}
+		calls = append(calls, call)
+	}
+	return
+}
+
+func handleOpenAI(s *Server, w http.ResponseWriter, r *ServerRequest) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.Path, "/models"):
+		data := []object{}
+		for _, i := range s.listModels() {
+			data = append(data, object{"id": i, "object": "model", "created": 0, "owned_by": "aitest"})
+		}
+		writeJSON(w, http.StatusOK, object{"object": "list", "data": data})
+		return
+	case r.Method == http.MethodPost && strings.HasSuffix(r.Path, "/embeddings"):
+		handleOpenAIEmbeddings(w, r)
+		return
+	case r.Method != http.MethodPost || !strings.HasSuffix(r.Path, "/chat/completions"):
+		openAIError(w, &StatusError{http.StatusNotFound, "not_found", "unknown path " + r.Path})
+		return
+	}
+	var req struct {
+		Model         string
+		Stream        bool
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	if err := r.JSON(&req); err != nil {
+		openAIError(w, &StatusError{http.StatusBadRequest, "invalid_request_error", err.Error()})
+		return
+	}
+	reply, err := s.next()
+	if err != nil {
+		openAIError(w, err)
+		return
+	}
+	id := fmt.Sprintf("chatcmpl-%d", len(s.Requests()))
+	if !req.Stream {
+		var choices []object
+		for i := range max(len(reply.Results), 1) {
+			message := object{"role": "assistant", "content": nil}
+			if i < len(reply.Results) {
+				message["content"] = reply.Results[i]
+			}
+			if i < len(reply.Thoughts) {
+				message["reasoning_content"] = reply.Thoughts[i]
+			}
+			finish := "stop"
+			if i == 0 && len(reply.FunctionCalls) > 0 {
+				index := -1
+				message["tool_calls"], finish = openAIToolCalls(reply, &index), "tool_calls"
+			}
+			choices = append(choices, object{"index": i, "message": message, "finish_reason": finish})
+		}
+		writeJSON(w, http.StatusOK, object{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": 0,
+			"model":   req.Model,
+			"choices": choices,
+			"usage":   openAIUsage(reply.TokenCount),
+		})
+		return
+	}
+	sse := newSSEWriter(w)
+	index := -1
+	var usage ai.TokenCount
+	for _, chunk := range reply.chunks() {
+		if chunk.Err != nil {
+			e := statusError(chunk.Err)
+			sse.event("", object{"error": object{"message": e.Message, "type": e.Code, "code": e.Code}})
+			return
+		}
+		if chunk.TokenCount != (ai.TokenCount{}) {
+			usage = chunk.TokenCount
+		}
+		if len(chunk.Results) == 0 && len(chunk.Thoughts) == 0 && len(chunk.FunctionCalls) == 0 {
+			continue
+		}
+		delta := object{"role": "assistant"}
+		if len(chunk.Results) > 0 {
+			delta["content"] = strings.Join(chunk.Results, "")
+		}
+		if len(chunk.Thoughts) > 0 {
+			delta["reasoning_content"] = strings.Join(chunk.Thoughts, "")
+		}
+		if len(chunk.FunctionCalls) > 0 {
+			delta["tool_calls"] = openAIToolCalls(chunk, &index)
+		}
+		sse.event("", object{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": 0,
+			"model":   req.Model,
+			"choices": []object{{"index": 0, "delta": delta, "finish_reason": nil}},
+		})
+	}
+	// As the real API does, usage is only sent when asked for, in a last chunk without choices.
+	if req.StreamOptions.IncludeUsage {
+		sse.event("", object{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": 0,
+			"model":   req.Model,
+			"choices": []object{},
+			"usage":   openAIUsage(usage),
+		})
+	}
+	sse.event("", "[DONE]")
+}
+
+func handleOpenAIEmbeddings(w http.ResponseWriter, r *ServerRequest) {
+	var req struct {
+		Input      json.RawMessage
+		Model      string
+		Dimensions int
+	}
+	if err := r.JSON(&req); err != nil {
+		openAIError(w, &StatusError{http.StatusBadRequest, "invalid_request_error", err.Error()})
+		return
+	}
+	var input []string
+	if err := json.Unmarshal(req.Input, &input); err != nil {
+		var s string
+		if err := json.Unmarshal(req.Input, &s); err != nil {
+			openAIError(w, &StatusError{http.StatusBadRequest, "invalid_request_error", "input must be a string or an array of strings"})
+			return
+		}
+		input = []string{s}
+	}
+	data := []object{}
+	var tokens int64
+	for n, i := range input {
+		data = append(data, object{"object": "embedding", "index": n, "embedding": Embedding(i, req.Dimensions)})
+		tokens += ai.EstimateTokens(ai.Text(i))
+	}
+	writeJSON(w, http.StatusOK, object{
+		"object": "list",
+		"data":   data,
+		"model":  req.Model,
+		"usage":  object{"prompt_tokens": tokens, "total_tokens": tokens},
+	})
+}
+
+// NewAnthropicServer returns a Server speaking the Anthropic messages API.
+// Use its URL as the endpoint of the anthropic provider.
+//
+// Stream usage is sent as the real API does: input tokens in the message_start event
+// and output tokens in the message_delta event.
+func NewAnthropicServer(replies ...Reply) *Server {
+	return newServer(handleAnthropic, replies)
+}
+
+// anthropicUsage returns the usage of a message, whose input tokens exclude cache reads and writes.
+func anthropicUsage(usage ai.TokenCount, output int64) object {
+	res := object{
+		"input_tokens":                usage.Prompt - usage.Cached - usage.CacheCreation,
+		"cache_read_input_tokens":     usage.Cached,
+		"cache_creation_input_tokens": usage.CacheCreation,
+		"output_tokens":               output,
+	}
+	if output > 0 {
+		res["output_tokens_details"] = object{"thinking_tokens": usage.Reasoning}
+	}
+	return res
+}
+
+func anthropicError(e *StatusError) object {
+	return object{"type": "error", "error": object{"type": e.Code, "message": e.Message}}
+}
+
+func handleAnthropic(s *Server, w http.ResponseWriter, r *ServerRequest) {
+	switch {
+	case r.Method == http.MethodGet && strings.Contains(r.Path, "/v1/models"):
+		data := []object{}
+		for _, i := range s.listModels() {
+			data = append(data, object{"id": i, "type": "model", "display_name": i, "created_at": "2025-01-01T00:00:00Z"})
+		}
+		writeJSON(w, http.StatusOK, object{"data": data, "has_more": false})
+		return
+	case r.Method == http.MethodPost && strings.HasSuffix(r.Path, "/v1/messages/count_tokens"):
+		writeJSON(w, http.StatusOK, object{"input_tokens": countTokens(r.Body)})
+		return
+	case r.Method != http.MethodPost || !strings.HasSuffix(r.Path, "/v1/messages"):
+		writeJSON(w, http.StatusNotFound, anthropicError(&StatusError{http.StatusNotFound, "not_found_error", "unknown path " + r.Path}))
+		return
+	}
+	var req struct {
+		Model  string
+		Stream bool
+	}
+	if err := r.JSON(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, anthropicError(&StatusError{http.StatusBadRequest, "invalid_request_error", err.Error()}))
+		return
+	}
+	reply, err := s.next()
+	if err != nil {
+		e := statusError(err)
+		writeJSON(w, e.StatusCode, anthropicError(e))
+		return
+	}
+	id := fmt.Sprintf("msg_%d", len(s.Requests()))
+	message := object{
+		"id":            id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         req.Model,
+		"content":       []object{},
+		"stop_reason":   nil,
+		"stop_sequence": nil,
+		"usage":         anthropicUsage(reply.TokenCount, reply.TokenCount.Result),
+	}
+	stopReason := "end_turn"
+	if !req.Stream {
+		var content []object
+		for _, i := range reply.Thoughts {
+			content = append(content, object{"type": "thinking", "thinking": i, "signature": signature(i)})
+		}
+		for _, i := range reply.Results {
+			content = append(content, object{"type": "text", "text": i})
+		}
+		for _, i := range reply.FunctionCalls {
+			content = append(content, object{"type": "tool_use", "id": i.ID, "name": i.Name, "input": arguments(i.Arguments)})
+			stopReason = "tool_use"
+		}
+		message["content"], message["stop_reason"] = content, stopReason
+		writeJSON(w, http.StatusOK, message)
+		return
+	}
+	var usage ai.TokenCount
+	for _, i := range reply.chunks() {
+		if i.TokenCount != (ai.TokenCount{}) {
+			usage = i.TokenCount
+		}
+	}
+	message["usage"] = anthropicUsage(usage, 0)
+	sse := newSSEWriter(w)
+	sse.event("message_start", object{"type": "message_start", "message": message})
+	index, block := -1, ""
+	var thinking strings.Builder
+	delta := func(typ string, d object) {
+		d["type"] = typ
+		sse.event("content_block_delta", object{"type": "content_block_delta", "index": index, "delta": d})
+	}
+	stop := func() {
+		if block == "thinking" {
+			delta("signature_delta", object{"signature": signature(thinking.String())})
+			thinking.Reset()
+		}
+		if block != "" {
+			sse.event("content_block_stop", object{"type": "content_block_stop", "index": index})
+		}
+	}
+	start := func(typ string, contentBlock object) {
+		stop()
+		index++
+		block = typ
+		sse.event("content_block_start", object{"type": "content_block_start", "index": index, "content_block": contentBlock})
+	}
+	for _, chunk := range reply.chunks() {
+		if chunk.Err != nil {
+			sse.event("error", anthropicError(statusError(chunk.Err)))
+			return
+		}
+		for _, i := range chunk.Thoughts {
+			if block != "thinking" {
+				start("thinking", object{"type": "thinking", "thinking": "", "signature": ""})
+			}
+			delta("thinking_delta", object{"thinking": i})
+			thinking.WriteString(i)
+		}
+		for _, i := range chunk.Results {
+			if block != "text" {
+				start("text", object{"type": "text", "text": ""})
+			}
+			delta("text_delta", object{"text": i})
+		}
+		for _, i := range chunk.FunctionCalls {
+			if i.ID != "" || block != "tool_use" {
+				start("tool_use", object{"type": "tool_use", "id": i.ID, "name": i.Name, "input": object{}})
+				stopReason = "tool_use"
+			}
+			if i.Arguments != "" {
+				delta("input_json_delta", object{"partial_json": i.Arguments})
+			}
+		}
+	}
+	stop()
+	sse.event("message_delta", object{
+		"type":  "message_delta",
+		"delta": object{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": object{"output_tokens": usage.Result, "output_tokens_details": object{"thinking_tokens": usage.Reasoning}},
+	})
+	sse.event("message_stop", object{"type": "message_stop"})
+}
+
+// NewGeminiServer returns a Server speaking the Gemini generateContent API.
+// Use its URL as the endpoint of the gemini provider.
+func NewGeminiServer(replies ...Reply) *Server {
+	return newServer(handleGemini, replies)
+}
+
+func geminiError(e *StatusError) object {
+	return object{"error": object{"code": e.StatusCode, "message": e.Message, "status": e.Code}}
+}
+
+// geminiResponse returns the response for reply, marking its candidates finished if last is set.
+func geminiResponse(reply Reply, model string, last bool) object {
+	var candidates []object
+	for i := range max(len(reply.Results), 1) {
+		var parts []object
+		if i == 0 {
+			for _, i := range reply.Thoughts {
+				parts = append(parts, object{"text": i, "thought": true})
+			}
+		}
+		if i < len(reply.Results) {
+			parts = append(parts, object{"text": reply.Results[i]})
+		}
+		if i == 0 {
+			for n, i := range reply.FunctionCalls {
+				call := object{"name": i.Name, "args": arguments(i.Arguments)}
+				if i.ID != "" {
+					call["id"] = i.ID
+				}
+				part := object{"functionCall": call}
+				// Gemini signs the first function call of a response.
+				if n == 0 {
+					part["thoughtSignature"] = []byte(signature(i.Name + i.Arguments))
+				}
+				parts = append(parts, part)
+			}
+		}
+		candidate := object{"index": i, "content": object{"role": "model", "parts": parts}}
+		if last {
+			candidate["finishReason"] = "STOP"
+		}
+		candidates = append(candidates, candidate)
+	}
+	resp := object{"candidates": candidates, "modelVersion": model}
+	if usage := reply.TokenCount; usage != (ai.TokenCount{}) {
+		resp["usageMetadata"] = object{
+			"promptTokenCount":        usage.Prompt - usage.ToolUse,
+			"candidatesTokenCount":    usage.Result - usage.Reasoning,
+			"thoughtsTokenCount":      usage.Reasoning,
+			"cachedContentTokenCount": usage.Cached,
+			"toolUsePromptTokenCount": usage.ToolUse,
+			"totalTokenCount":         usage.Total,
+		}
+	}
+	return resp
+}
+
+func handleGemini(s *Server, w http.ResponseWriter, r *ServerRequest) {
+	path, _, _ := strings.Cut(r.Path, "?")
+	if r.Method == http.MethodGet && strings.HasSuffix(path, "/models") {
+		models := []object{}
+		for _, i := range s.listModels() {
+			models = append(models, object{"name": "models/" + strings.TrimPrefix(i, "models/")})
+		}
+		writeJSON(w, http.StatusOK, object{"models": models})
+		return
+	}
+	_, method, _ := strings.Cut(path[strings.LastIndex(path, "/")+1:], ":")
+	if r.Method == http.MethodPost && method == "batchEmbedContents" {
+		handleGeminiEmbeddings(w, r)
+		return
+	} else if r.Method == http.MethodPost && method == "countTokens" {
+		writeJSON(w, http.StatusOK, object{"totalTokens": countTokens(r.Body)})
+		return
+	}
+	if r.Method != http.MethodPost || method != "generateContent" && method != "streamGenerateContent" {
+		writeJSON(w, http.StatusNotFound, geminiError(&StatusError{http.StatusNotFound, "NOT_FOUND", "unknown path " + r.Path}))
+		return
+	}
+	model, _, _ := strings.Cut(path[strings.LastIndex(path, "/")+1:], ":")
+	reply, err := s.next()
+	if err != nil {
+		e := statusError(err)
+		writeJSON(w, e.StatusCode, geminiError(e))
+		return
+	}
+	if method == "generateContent" {
+		writeJSON(w, http.StatusOK, geminiResponse(reply, model, true))
+		return
+	}
+	sse := newSSEWriter(w)
+	chunks := reply.chunks()
+	for n, chunk := range chunks {
+		if chunk.Err != nil {
+			b, _ := json.Marshal(geminiError(statusError(chunk.Err)))
+			fmt.Fprintf(w, "%s\n\n", b)
+			return
+		}
+		sse.event("", geminiResponse(chunk, model, n == len(chunks)-1))
+	}
+}
+
+func handleGeminiEmbeddings(w http.ResponseWriter, r *ServerRequest) {
+	var req struct {
+		Requests []struct {
+			Content struct {
+				Parts []struct{ Text string }
+			}
+			OutputDimensionality int
+		}
+	}
+	if err := r.JSON(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, geminiError(&StatusError{http.StatusBadRequest, "INVALID_ARGUMENT", err.Error()}))
+		return
+	}
+	embeddings := []object{}
+	for _, i := range req.Requests {
+		var text []string
+		for _, part := range i.Content.Parts {
+			text = append(text, part.Text)
+		}
+		embeddings = append(embeddings, object{"values": Embedding(strings.Join(text, ""), i.OutputDimensionality)})
+	}
+	writeJSON(w, http.StatusOK, object{"embeddings": embeddings})
+}
@@ -0,0 +1,36 @@
+package aitest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sunshineplan/ai"
+)
+
+func TestOpenAIStreamUsage(t *testing.T) {
+	reply := Reply{Chunks: []Reply{Text("a"), {TokenCount: ai.TokenCount{Prompt: 1, Result: 1, Total: 2}}}}
+	s := NewOpenAIServer(reply, reply)
+	defer s.Close()
+	for _, tc := range []struct {
+		body  string
+		usage bool
+	}{
+		{`{"model":"test","stream":true}`, false},
+		{`{"model":"test","stream":true,"stream_options":{"include_usage":true}}`, true},
+	} {
+		resp, err := http.Post(s.URL+"/chat/completions", "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage := strings.Contains(string(b), `"choices":[]`) && strings.Contains(string(b), `"usage"`); usage != tc.usage {
+			t.Errorf("%s: expected usage %t; got %s", tc.body, tc.usage, b)
+		}
+	}
+}
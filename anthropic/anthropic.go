@@ -4,13 +4,11 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math"
-	"net/http"
-	"net/url"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/sunshineplan/ai"
 
@@ -18,26 +16,51 @@ import (
 	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/anthropics/anthropic-sdk-go/packages/param"
 	"github.com/anthropics/anthropic-sdk-go/packages/ssestream"
-	"golang.org/x/time/rate"
 )
 
 const defaultModel = anthropic.ModelClaudeSonnet4_6
 
 var DefaultMaxTokens int64 = 64000
 
+// MinThinkingBudget is the minimum thinking budget accepted by Anthropic.
+const MinThinkingBudget = 1024
+
 var _ ai.AI = new(Anthropic)
 
+func init() {
+	ai.Register(ai.Anthropic, func(_ context.Context, opts ...ai.ClientOption) (ai.AI, error) {
+		return New(opts...)
+	})
+	ai.RegisterErrorClassifier(func(err error) (info ai.ErrorInfo, ok bool) {
+		var e *anthropic.Error
+		if ok = errors.As(err, &e); ok {
+			info.StatusCode = e.StatusCode
+			info.QuotaExceeded = e.Type() == anthropic.ErrorTypeBillingError
+			if e.Response != nil {
+				info.RetryAfter = ai.RetryAfter(e.Response.Header)
+			}
+		}
+		return
+	})
+}
+
 type Anthropic struct {
 	*anthropic.Client
 	model       anthropic.Model
+	system      []anthropic.TextBlockParam
 	toolChoice  anthropic.ToolChoiceUnionParam
 	tools       []anthropic.ToolUnionParam
 	maxTokens   *int64
 	temperature *float64
 	topP        *float64
-	thinking    bool
+	thinking    *ai.ThinkingConfig
+	json        *jsonTool
+
+	systemErr error
+	toolsErr  error
+	schemaErr error
 
-	limiter *rate.Limiter
+	ai.Limits
 }
 
 func New(opts ...ai.ClientOption) (ai.AI, error) {
@@ -51,22 +74,23 @@ func New(opts ...ai.ClientOption) (ai.AI, error) {
 	if cfg.Endpoint != "" {
 		options = append(options, option.WithBaseURL(cfg.Endpoint))
 	}
-	if cfg.Proxy != "" {
-		u, err := url.Parse(cfg.Proxy)
-		if err != nil {
-			return nil, err
-		}
-		if t, ok := http.DefaultTransport.(*http.Transport); ok {
-			t = t.Clone()
-			t.Proxy = http.ProxyURL(u)
-			options = append(options, option.WithHTTPClient(&http.Client{Transport: t}))
-		}
+	client, err := ai.NewHTTPClient(*cfg)
+	if err != nil {
+		return nil, err
 	}
-	c := NewWithClient(anthropic.NewClient(options...), cfg.Model)
-	if cfg.Limit != nil {
-		c.SetLimit(*cfg.Limit)
+	if client != nil {
+		options = append(options, option.WithHTTPClient(client))
 	}
+	if cfg.Retry != nil {
+		// Leave retrying to the policy.
+		options = append(options, option.WithMaxRetries(0))
+	}
+	c := NewWithClient(anthropic.NewClient(options...), cfg.Model)
+	ai.ApplyLimits(c, *cfg)
 	ai.ApplyModelConfig(c, cfg.ModelConfig)
+	if cfg.Retry != nil {
+		return ai.Retry(c, *cfg.Retry), nil
+	}
 	return c, nil
 }
 
@@ -77,7 +101,7 @@ func NewWithClient(client anthropic.Client, model string) ai.AI {
 	return &Anthropic{Client: &client, model: anthropic.Model(model)}
 }
 
-func (Anthropic) LLMs() ai.LLMs {
+func (*Anthropic) LLMs() ai.LLMs {
 	return ai.Anthropic
 }
 
@@ -85,38 +109,81 @@ func (anthropic *Anthropic) Model() string {
 	return string(anthropic.model)
 }
 
-func (anthropic *Anthropic) SetLimit(rpm int64) {
-	anthropic.limiter = ai.NewLimiter(rpm)
+// wait acquires the limits for a request, estimating the tokens of the system instruction,
+// the history and the parts.
+func (a *Anthropic) wait(ctx context.Context, history []anthropic.MessageParam, parts ...ai.Part) (ai.Release, error) {
+	if err := errors.Join(a.systemErr, a.toolsErr, a.schemaErr); err != nil {
+		return nil, err
+	}
+	if a.json != nil && !a.forceJSON() && a.json.format.Schema == nil {
+		return nil, errors.New("anthropic: JSON response without schema is not supported with thinking or function calling")
+	}
+	if maxTokens := a.maxTokensOrDefault(); a.thinking != nil && maxTokens <= MinThinkingBudget {
+		return nil, fmt.Errorf("anthropic: max tokens %d leave no room for the minimum thinking budget %d", maxTokens, MinThinkingBudget)
+	}
+	tokens := ai.EstimateTokens(parts...) + ai.EstimateHistory((&ChatSession{ai: a, history: history}).History())
+	for _, i := range a.system {
+		tokens += ai.EstimateTokens(ai.Text(i.Text))
+	}
+	return a.Acquire(ctx, tokens)
 }
 
-func (anthropic *Anthropic) Limit() (rpm int64) {
-	if anthropic.limiter == nil {
-		return math.MaxInt64
+func (ai *Anthropic) SetModel(model string) { ai.model = anthropic.Model(model) }
+func (a *Anthropic) SetSystemInstruction(parts ...ai.Part) {
+	a.system, a.systemErr = nil, nil
+	for _, i := range parts {
+		if v, ok := i.(ai.Text); ok {
+			a.system = append(a.system, anthropic.TextBlockParam{Text: string(v)})
+		} else {
+			a.systemErr = fmt.Errorf("anthropic: unsupported system instruction part %T", i)
+		}
 	}
-	return int64(anthropic.limiter.Limit() / rate.Every(time.Minute))
 }
-
-func (ai *Anthropic) wait(ctx context.Context) error {
-	if ai.limiter != nil {
-		return ai.limiter.Wait(ctx)
+func toolInputSchema(schema ai.Schema) (res anthropic.ToolInputSchemaParam, err error) {
+	if schema.Type != "" && schema.Type != "object" {
+		err = fmt.Errorf("input schema type must be object, got %q", schema.Type)
+		return
 	}
-	return nil
+	if schema.Nullable {
+		err = errors.New("input schema must not be nullable")
+		return
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return
+	}
+	var m map[string]any
+	if err = json.Unmarshal(b, &m); err != nil {
+		return
+	}
+	res.Properties = m["properties"]
+	res.Required = schema.Required
+	delete(m, "type")
+	delete(m, "properties")
+	delete(m, "required")
+	if len(m) > 0 {
+		res.ExtraFields = m
+	}
+	return
 }
 
-func (ai *Anthropic) SetModel(model string) { ai.model = anthropic.Model(model) }
 func (a *Anthropic) SetFunctionCall(f []ai.Function, mode ai.FunctionCallingMode) {
+	a.toolsErr = nil
 	if a.tools = nil; len(f) == 0 {
 		a.toolChoice = anthropic.ToolChoiceUnionParam{}
 		return
 	}
 	for _, i := range f {
+		schema, err := toolInputSchema(i.Parameters)
+		if err != nil {
+			a.toolsErr = errors.Join(a.toolsErr, fmt.Errorf("anthropic: function %q: %w", i.Name, err))
+			continue
+		}
 		a.tools = append(a.tools, anthropic.ToolUnionParam{
 			OfTool: &anthropic.ToolParam{
 				Name:        i.Name,
 				Description: anthropic.String(i.Description),
-				InputSchema: anthropic.ToolInputSchemaParam{
-					Properties: i.Parameters,
-				},
+				InputSchema: schema,
 			},
 		})
 	}
@@ -134,13 +201,24 @@ func (a *Anthropic) SetFunctionCall(f []ai.Function, mode ai.FunctionCallingMode
 func (ai *Anthropic) SetMaxTokens(i int64)     { ai.maxTokens = &i }
 func (ai *Anthropic) SetTemperature(f float64) { ai.temperature = &f }
 func (ai *Anthropic) SetTopP(f float64)        { ai.topP = &f }
-func (ai *Anthropic) SetThinking(set bool)     { ai.thinking = set }
+
+func (a *Anthropic) SetThinking(set bool) {
+	if set {
+		a.SetThinkingConfig(ai.ThinkingConfig{})
+	} else {
+		a.thinking = nil
+	}
+}
+func (ai *Anthropic) SetThinkingConfig(cfg ai.ThinkingConfig) { ai.thinking = &cfg }
 
 func (ai *Anthropic) SetCount(i int64) {
 	fmt.Println("Anthropic doesn't support SetCount")
 }
-func (ai *Anthropic) SetJSONResponse(_ bool, _ *ai.JSONSchema) {
-	fmt.Println("Anthropic currently doesn't support SetJSONResponse")
+func (ai *Anthropic) SetJSONResponse(set bool, schema *ai.JSONSchema) {
+	ai.json, ai.schemaErr = nil, nil
+	if set {
+		ai.json, ai.schemaErr = newJSONTool(schema)
+	}
 }
 
 func (ai *Anthropic) ListModels(ctx context.Context) ([]string, error) {
@@ -164,6 +242,15 @@ type ChatCompletionResponse interface {
 
 type ChatResponse[Response ChatCompletionResponse] struct {
 	resp Response
+	json *jsonTool
+
+	// jsonBlock reports whether the stream event belongs to the JSON response tool use.
+	jsonBlock bool
+	// jsonResult holds the complete JSON result of a wrapped JSON response
+	// and is set on the stream event which stops its content block.
+	jsonResult string
+	// usage holds the usage of the stream so far and is set on its message_start and message_delta events.
+	usage *anthropic.Usage
 }
 
 func (resp *ChatResponse[Response]) Raw() any {
@@ -173,14 +260,32 @@ func (resp *ChatResponse[Response]) Raw() any {
 func (resp *ChatResponse[Response]) Results() (res []string) {
 	switch v := any(resp.resp).(type) {
 	case *anthropic.Message:
+		if resp.json != nil {
+			for _, i := range v.Content {
+				if v, ok := i.AsAny().(anthropic.ToolUseBlock); ok && v.Name == resp.json.tool.Name {
+					res = append(res, resp.json.result(v.Input))
+				}
+			}
+			if len(res) > 0 {
+				return
+			}
+		}
 		for _, i := range v.Content {
 			if v, ok := i.AsAny().(anthropic.TextBlock); ok {
 				res = append(res, v.Text)
 			}
 		}
 	case anthropic.MessageStreamEventUnion:
+		if resp.jsonResult != "" {
+			res = append(res, resp.jsonResult)
+		}
 		switch v := v.AsAny().(type) {
 		case anthropic.ContentBlockDeltaEvent:
+			if v, ok := v.Delta.AsAny().(anthropic.InputJSONDelta); ok && resp.jsonBlock && !resp.json.wrapped {
+				if v.PartialJSON != "" {
+					res = append(res, v.PartialJSON)
+				}
+			}
 			if v, ok := v.Delta.AsAny().(anthropic.TextDelta); ok {
 				if v.Text != "" {
 					res = append(res, v.Text)
@@ -217,10 +322,16 @@ func (resp *ChatResponse[Response]) FunctionCalls() (res []ai.FunctionCall) {
 	case *anthropic.Message:
 		for _, i := range v.Content {
 			if v, ok := i.AsAny().(anthropic.ToolUseBlock); ok {
+				if resp.json != nil && v.Name == resp.json.tool.Name {
+					continue
+				}
 				res = append(res, ai.FunctionCall{ID: v.ID, Name: v.Name, Arguments: string(v.Input)})
 			}
 		}
 	case anthropic.MessageStreamEventUnion:
+		if resp.jsonBlock {
+			return
+		}
 		switch v := v.AsAny().(type) {
 		case anthropic.ContentBlockDeltaEvent:
 			if v, ok := v.Delta.AsAny().(anthropic.InputJSONDelta); ok {
@@ -236,16 +347,26 @@ func (resp *ChatResponse[Response]) FunctionCalls() (res []ai.FunctionCall) {
 	return
 }
 
-func (resp *ChatResponse[Response]) TokenCount() (res ai.TokenCount) {
+func (resp *ChatResponse[Response]) TokenCount() ai.TokenCount {
 	switch v := any(resp.resp).(type) {
 	case *anthropic.Message:
-		res.Prompt = v.Usage.InputTokens
-		res.Result = v.Usage.OutputTokens
+		return tokenCount(v.Usage)
 	case anthropic.MessageStreamEventUnion:
-		res.Prompt = v.Message.Usage.InputTokens
-		res.Result = v.Message.Usage.OutputTokens
+		if resp.usage != nil {
+			return tokenCount(*resp.usage)
+		}
 	}
+	return ai.TokenCount{}
+}
+
+// tokenCount counts cache reads and writes in Prompt, as input tokens of Anthropic exclude them.
+func tokenCount(usage anthropic.Usage) (res ai.TokenCount) {
+	res.Prompt = usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens
+	res.Result = usage.OutputTokens
 	res.Total = res.Prompt + res.Result
+	res.Cached = usage.CacheReadInputTokens
+	res.CacheCreation = usage.CacheCreationInputTokens
+	res.Reasoning = usage.OutputTokensDetails.ThinkingTokens
 	return
 }
 
@@ -281,59 +402,82 @@ func fromImageBlockSource(img anthropic.ImageBlockParamSourceUnion) ai.Image {
 	panic(fmt.Sprintf("bad image: %v", img))
 }
 
+func (c *Anthropic) maxTokensOrDefault() int64 {
+	if c.maxTokens != nil {
+		return *c.maxTokens
+	}
+	return DefaultMaxTokens
+}
+
+// forceJSON reports whether JSON responses can be enforced by forcing the JSON tool,
+// which is not compatible with extended thinking or other tools.
+func (c *Anthropic) forceJSON() bool {
+	return c.thinking == nil && len(c.tools) == 0
+}
+
 func (c *Anthropic) createRequest(
 	history []anthropic.MessageParam,
 	messages ...ai.Part,
 ) (req anthropic.MessageNewParams) {
 	req.Model = c.model
+	if len(c.system) > 0 {
+		req.System = c.system
+	}
 	if !param.IsOmitted(c.toolChoice) {
 		req.ToolChoice = c.toolChoice
 	}
 	if len(c.tools) > 0 {
 		req.Tools = c.tools
 	}
-	if c.maxTokens != nil {
-		req.MaxTokens = *c.maxTokens
-	} else {
-		req.MaxTokens = DefaultMaxTokens
-	}
+	req.MaxTokens = c.maxTokensOrDefault()
 	if c.temperature != nil {
 		req.Temperature = anthropic.Float(*c.temperature)
 	}
 	if c.topP != nil {
 		req.TopP = anthropic.Float(*c.topP)
 	}
-	if c.thinking {
-		req.Thinking = anthropic.ThinkingConfigParamOfEnabled(10000)
+	if c.thinking != nil {
+		// The budget must be at least MinThinkingBudget and less than max tokens.
+		budget := min(max(c.thinking.BudgetTokens(), MinThinkingBudget), req.MaxTokens-1)
+		req.Thinking = anthropic.ThinkingConfigParamOfEnabled(budget)
+	}
+	if c.json != nil {
+		if c.forceJSON() {
+			req.Tools = []anthropic.ToolUnionParam{{OfTool: &c.json.tool}}
+			req.ToolChoice = anthropic.ToolChoiceParamOfTool(c.json.tool.Name)
+		} else {
+			req.OutputConfig.Format = c.json.format
+		}
 	}
 	var msgs []anthropic.MessageParam
-	for _, i := range history {
-		var content []anthropic.ContentBlockParamUnion
-		for _, v := range i.Content {
-			if v.OfToolUse != nil {
-				continue
-			}
-			content = append(content, v)
-		}
-		msgs = append(msgs, anthropic.MessageParam{
-			Role:    i.Role,
-			Content: content,
-		})
+	msgs = append(msgs, history...)
+	if msg, ok := toUserMessage(messages...); ok {
+		msgs = append(msgs, msg)
 	}
+	req.Messages = msgs
+	return
+}
+
+// toUserMessage puts all parts into one user message with tool results first,
+// as required when replying to tool use.
+func toUserMessage(messages ...ai.Part) (anthropic.MessageParam, bool) {
+	var results, content []anthropic.ContentBlockParamUnion
 	for _, i := range messages {
 		switch v := i.(type) {
 		case ai.Text:
-			msgs = append(msgs, anthropic.NewUserMessage(anthropic.NewTextBlock(string(v))))
+			content = append(content, anthropic.NewTextBlock(string(v)))
 		case ai.Image:
-			msgs = append(msgs, anthropic.NewUserMessage(toImageBlock(v)))
+			content = append(content, toImageBlock(v))
 		case ai.Blob:
-			msgs = append(msgs, anthropic.NewUserMessage(toImageBlock(ai.ImageData(v.MIMEType, v.Data))))
+			content = append(content, toImageBlock(ai.ImageData(v.MIMEType, v.Data)))
 		case ai.FunctionResponse:
-			msgs = append(msgs, anthropic.NewUserMessage(anthropic.NewToolResultBlock(v.ID, v.Response, false)))
+			results = append(results, anthropic.NewToolResultBlock(v.ID, v.Response, false))
 		}
 	}
-	req.Messages = msgs
-	return
+	if content = append(results, content...); len(content) == 0 {
+		return anthropic.MessageParam{}, false
+	}
+	return anthropic.NewUserMessage(content...), true
 }
 
 func (anthropic *Anthropic) chat(
@@ -345,18 +489,25 @@ func (anthropic *Anthropic) chat(
 		err = ai.ErrAIClosed
 		return
 	}
-	if err = anthropic.wait(ctx); err != nil {
+	release, err := anthropic.wait(ctx, history, messages...)
+	if err != nil {
 		return
 	}
-	return anthropic.Client.Messages.New(ctx, anthropic.createRequest(history, messages...))
+	var usage ai.TokenCount
+	defer func() { release.Done(usage) }()
+	if resp, err = anthropic.Client.Messages.New(ctx, anthropic.createRequest(history, messages...)); err == nil {
+		usage = tokenCount(resp.Usage)
+	}
+	return
 }
 
 func (ai *Anthropic) Chat(ctx context.Context, messages ...ai.Part) (ai.ChatResponse, error) {
+	tool := ai.json
 	resp, err := ai.chat(ctx, nil, messages...)
 	if err != nil {
 		return nil, err
 	}
-	return &ChatResponse[*anthropic.Message]{resp}, nil
+	return &ChatResponse[*anthropic.Message]{resp: resp, json: tool}, nil
 }
 
 var _ ai.ChatStream = new(ChatStream)
@@ -365,6 +516,15 @@ type ChatStream struct {
 	stream  *ssestream.Stream[anthropic.MessageStreamEventUnion]
 	session *ChatSession
 	message anthropic.Message
+	usage   anthropic.Usage
+
+	json      *jsonTool
+	jsonIndex int64
+	jsonInput strings.Builder
+}
+
+func newChatStream(stream *ssestream.Stream[anthropic.MessageStreamEventUnion], session *ChatSession, json *jsonTool) *ChatStream {
+	return &ChatStream{stream: stream, session: session, json: json, jsonIndex: -1}
 }
 
 func (cs *ChatStream) Next() (ai.ChatResponse, error) {
@@ -375,14 +535,59 @@ func (cs *ChatStream) Next() (ai.ChatResponse, error) {
 				return nil, err
 			}
 		}
-		return &ChatResponse[anthropic.MessageStreamEventUnion]{resp}, nil
+		res := &ChatResponse[anthropic.MessageStreamEventUnion]{resp: resp, json: cs.json}
+		switch v := resp.AsAny().(type) {
+		case anthropic.MessageStartEvent:
+			cs.usage = v.Message.Usage
+			usage := cs.usage
+			res.usage = &usage
+		case anthropic.MessageDeltaEvent:
+			// The usage of message_delta is cumulative, but may leave out the input tokens.
+			cs.usage.OutputTokens = v.Usage.OutputTokens
+			cs.usage.OutputTokensDetails = v.Usage.OutputTokensDetails
+			if v.Usage.InputTokens > 0 {
+				cs.usage.InputTokens = v.Usage.InputTokens
+			}
+			if v.Usage.CacheReadInputTokens > 0 {
+				cs.usage.CacheReadInputTokens = v.Usage.CacheReadInputTokens
+			}
+			if v.Usage.CacheCreationInputTokens > 0 {
+				cs.usage.CacheCreationInputTokens = v.Usage.CacheCreationInputTokens
+			}
+			usage := cs.usage
+			res.usage = &usage
+		}
+		if cs.json != nil {
+			switch v := resp.AsAny().(type) {
+			case anthropic.ContentBlockStartEvent:
+				if v, ok := v.ContentBlock.AsAny().(anthropic.ToolUseBlock); ok && v.Name == cs.json.tool.Name {
+					cs.jsonIndex = resp.Index
+					res.jsonBlock = true
+				}
+			case anthropic.ContentBlockDeltaEvent:
+				if v.Index == cs.jsonIndex {
+					res.jsonBlock = true
+					if v, ok := v.Delta.AsAny().(anthropic.InputJSONDelta); ok {
+						cs.jsonInput.WriteString(v.PartialJSON)
+					}
+				}
+			case anthropic.ContentBlockStopEvent:
+				if v.Index == cs.jsonIndex {
+					res.jsonBlock = true
+					if cs.json.wrapped {
+						res.jsonResult = cs.json.result(json.RawMessage(cs.jsonInput.String()))
+					}
+				}
+			}
+		}
+		return res, nil
 	}
 	if err := cs.stream.Err(); err != nil {
 		cs.message = anthropic.Message{}
 		return nil, err
 	}
 	if cs.session != nil {
-		cs.session.history = append(cs.session.history, cs.message.ToParam())
+		cs.session.history = append(cs.session.history, cs.json.toParam(&cs.message))
 	}
 	return nil, io.EOF
 }
@@ -395,22 +600,24 @@ func (anthropic *Anthropic) chatStream(
 	ctx context.Context,
 	history []anthropic.MessageParam,
 	messages ...ai.Part,
-) (*ssestream.Stream[anthropic.MessageStreamEventUnion], error) {
+) (*ssestream.Stream[anthropic.MessageStreamEventUnion], ai.Release, error) {
 	if anthropic.Client == nil {
-		return nil, ai.ErrAIClosed
+		return nil, nil, ai.ErrAIClosed
 	}
-	if err := anthropic.wait(ctx); err != nil {
-		return nil, err
+	release, err := anthropic.wait(ctx, history, messages...)
+	if err != nil {
+		return nil, nil, err
 	}
-	return anthropic.Client.Messages.NewStreaming(ctx, anthropic.createRequest(history, messages...)), nil
+	return anthropic.Client.Messages.NewStreaming(ctx, anthropic.createRequest(history, messages...)), release, nil
 }
 
 func (ai *Anthropic) ChatStream(ctx context.Context, messages ...ai.Part) (ai.ChatStream, error) {
-	stream, err := ai.chatStream(ctx, nil, messages...)
+	tool := ai.json
+	stream, release, err := ai.chatStream(ctx, nil, messages...)
 	if err != nil {
 		return nil, err
 	}
-	return &ChatStream{stream: stream}, nil
+	return release.Stream(newChatStream(stream, nil, tool)), nil
 }
 
 var _ ai.ChatSession = new(ChatSession)
@@ -421,78 +628,169 @@ type ChatSession struct {
 }
 
 func (session *ChatSession) addUserHistory(messages ...ai.Part) {
-	for _, i := range messages {
-		switch v := i.(type) {
-		case ai.Text:
-			session.history = append(session.history, anthropic.NewUserMessage(anthropic.NewTextBlock(string(v))))
-		case ai.Image:
-			session.history = append(session.history, anthropic.NewUserMessage(toImageBlock(v)))
-		case ai.Blob:
-			session.history = append(session.history, anthropic.NewUserMessage(toImageBlock(ai.ImageData(v.MIMEType, v.Data))))
-		case ai.FunctionResponse:
-			session.history = append(session.history, anthropic.NewUserMessage(anthropic.NewToolResultBlock(v.ID, v.Response, false)))
-		}
+	if msg, ok := toUserMessage(messages...); ok {
+		session.history = append(session.history, msg)
 	}
 }
 
 func (session *ChatSession) Chat(ctx context.Context, messages ...ai.Part) (ai.ChatResponse, error) {
+	tool := session.ai.json
 	resp, err := session.ai.chat(ctx, session.history, messages...)
 	if err != nil {
 		return nil, err
 	}
 	session.addUserHistory(messages...)
-	session.history = append(session.history, resp.ToParam())
-	return &ChatResponse[*anthropic.Message]{resp}, nil
+	session.history = append(session.history, tool.toParam(resp))
+	return &ChatResponse[*anthropic.Message]{resp: resp, json: tool}, nil
 }
 
 func (session *ChatSession) ChatStream(ctx context.Context, messages ...ai.Part) (ai.ChatStream, error) {
-	stream, err := session.ai.chatStream(ctx, session.history, messages...)
+	tool := session.ai.json
+	stream, release, err := session.ai.chatStream(ctx, session.history, messages...)
 	if err != nil {
 		return nil, err
 	}
 	session.addUserHistory(messages...)
-	return &ChatStream{stream: stream, session: session}, nil
+	return release.Stream(newChatStream(stream, session, tool)), nil
 }
 
 func (session *ChatSession) History() (history []ai.Content) {
 	for _, i := range session.history {
+		var parts []ai.Part
 		for _, v := range i.Content {
+			if v.OfThinking != nil {
+				parts = append(parts, ai.Thought{Text: v.OfThinking.Thinking, Signature: []byte(v.OfThinking.Signature), Provider: ai.Anthropic})
+			}
+			if v.OfRedactedThinking != nil {
+				parts = append(parts, ai.Thought{Signature: []byte(v.OfRedactedThinking.Data), Redacted: true, Provider: ai.Anthropic})
+			}
 			if v.OfText != nil {
 				if text := v.OfText.Text; text != "" {
-					history = append(history, ai.Content{Role: string(i.Role), Parts: []ai.Part{ai.Text(text)}})
+					parts = append(parts, ai.Text(text))
 				}
 			}
 			if v.OfImage != nil {
-				history = append(history, ai.Content{Role: string(i.Role), Parts: []ai.Part{
-					fromImageBlockSource(v.OfImage.Source),
-				}})
+				parts = append(parts, fromImageBlockSource(v.OfImage.Source))
 			}
 			if v.OfToolUse != nil {
 				args, err := json.Marshal(v.OfToolUse.Input)
 				if err != nil {
 					panic(err)
 				}
-				history = append(history, ai.Content{Role: string(i.Role), Parts: []ai.Part{ai.FunctionCall{
+				parts = append(parts, ai.FunctionCall{
 					ID:        v.OfToolUse.ID,
 					Name:      v.OfToolUse.Name,
 					Arguments: string(args),
-				}}})
+				})
 			}
 			if v.OfToolResult != nil {
 				for _, ii := range v.OfToolResult.Content {
 					if ii.OfText != nil {
-						history = append(history, ai.Content{Role: string(i.Role), Parts: []ai.Part{ai.FunctionResponse{
+						parts = append(parts, ai.FunctionResponse{
 							ID:       v.OfToolResult.ToolUseID,
 							Response: ii.OfText.Text,
-						}}})
+						})
 					}
 				}
 			}
 		}
+		if len(parts) > 0 {
+			history = append(history, ai.Content{Role: string(i.Role), Parts: parts})
+		}
 	}
 	return
 }
 
+func (session *ChatSession) SetHistory(history []ai.Content) error {
+	msgs, err := toMessageParams(history)
+	if err != nil {
+		return err
+	}
+	session.history = msgs
+	return nil
+}
+
+func (session *ChatSession) SnapshotHistory() func() {
+	history := slices.Clone(session.history)
+	return func() { session.history = history }
+}
+
+func toMessageParams(history []ai.Content) (msgs []anthropic.MessageParam, err error) {
+	// Consecutive user and tool contents are merged, as anthropic expects tool results
+	// and the user message that follows them in a single turn.
+	var user []ai.Part
+	flush := func() {
+		if msg, ok := toUserMessage(user...); ok {
+			msgs = append(msgs, msg)
+		}
+		user = nil
+	}
+	for _, content := range ai.NormalizeHistory(history) {
+		switch content.Role {
+		case ai.RoleAssistant:
+			flush()
+			var blocks []anthropic.ContentBlockParamUnion
+			for _, i := range content.Parts {
+				switch v := i.(type) {
+				case ai.Text:
+					blocks = append(blocks, anthropic.NewTextBlock(string(v)))
+				case ai.FunctionCall:
+					input := json.RawMessage(v.Arguments)
+					if len(input) == 0 {
+						input = json.RawMessage("{}")
+					} else if !json.Valid(input) {
+						return nil, fmt.Errorf("anthropic: invalid arguments of function call %q", v.Name)
+					}
+					blocks = append(blocks, anthropic.NewToolUseBlock(v.ID, input, v.Name))
+				case ai.Thought:
+					// Thinking blocks are only accepted back with their own signature.
+					if v.Provider != ai.Anthropic {
+						continue
+					} else if v.Redacted {
+						blocks = append(blocks, anthropic.NewRedactedThinkingBlock(string(v.Signature)))
+					} else if len(v.Signature) > 0 {
+						blocks = append(blocks, anthropic.NewThinkingBlock(string(v.Signature), v.Text))
+					}
+				default:
+					return nil, fmt.Errorf("anthropic: unsupported assistant part %T", i)
+				}
+			}
+			if len(blocks) > 0 {
+				msgs = append(msgs, anthropic.NewAssistantMessage(blocks...))
+			}
+		default:
+			for _, i := range content.Parts {
+				switch i.(type) {
+				case ai.Text, ai.Image, ai.Blob, ai.FunctionResponse:
+				default:
+					return nil, fmt.Errorf("anthropic: unsupported %s part %T", content.Role, i)
+				}
+			}
+			user = append(user, content.Parts...)
+		}
+	}
+	flush()
+	return
+}
+
+func (a *Anthropic) CountTokens(ctx context.Context, parts ...ai.Part) (int64, error) {
+	if a.Client == nil {
+		return 0, ai.ErrAIClosed
+	}
+	msg, ok := toUserMessage(parts...)
+	if !ok {
+		return 0, nil
+	}
+	resp, err := a.Client.Messages.CountTokens(ctx, anthropic.MessageCountTokensParams{
+		Model:    a.model,
+		Messages: []anthropic.MessageParam{msg},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resp.InputTokens, nil
+}
+
 func (ai *Anthropic) ChatSession() ai.ChatSession {
 	return &ChatSession{ai: ai}
 }
@@ -0,0 +1,302 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sunshineplan/ai"
+	"github.com/sunshineplan/ai/aitest"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+)
+
+func TestToolInputSchema(t *testing.T) {
+	s, err := toolInputSchema(ai.Schema{
+		Type:        "object",
+		Description: "args",
+		Properties: map[string]*ai.Schema{
+			"name": {Type: "string", Nullable: true},
+		},
+		Required: []string{"name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := `{"properties":{"name":{"type":["string","null"]}},"required":["name"],"type":"object","description":"args"}`; string(b) != expect {
+		t.Errorf("expected %s; got %s", expect, b)
+	}
+	if _, err := toolInputSchema(ai.Schema{Type: "array"}); err == nil {
+		t.Error("expected error; got nil")
+	}
+}
+
+func TestJSONTool(t *testing.T) {
+	tool, err := newJSONTool(&ai.JSONSchema{
+		Name:   "color list",
+		Schema: ai.Schema{Type: "array", Items: &ai.Schema{Type: "string"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tool.tool.Name != "color_list" {
+		t.Errorf("expected color_list; got %s", tool.tool.Name)
+	}
+	if !tool.wrapped {
+		t.Fatal("expected wrapped schema")
+	}
+	resp := &ChatResponse[*anthropic.Message]{json: tool}
+	if err := json.Unmarshal([]byte(`{"content":[{"type":"tool_use","id":"1","name":"color_list","input":{"response":["red","blue"]}}]}`), &resp.resp); err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); len(res) != 1 || res[0] != `["red","blue"]` {
+		t.Errorf("unexpected results: %q", res)
+	}
+	if calls := resp.FunctionCalls(); len(calls) != 0 {
+		t.Errorf("expected no function calls; got %v", calls)
+	}
+	if p := tool.toParam(resp.resp); p.Content[0].OfText == nil || p.Content[0].OfText.Text != `["red","blue"]` {
+		t.Errorf("unexpected history: %#v", p.Content[0])
+	}
+}
+
+func TestJSONOutputFormat(t *testing.T) {
+	c := NewWithClient(anthropic.NewClient(), "").(*Anthropic)
+	c.SetMaxTokens(4096)
+	c.SetJSONResponse(true, &ai.JSONSchema{Name: "colors", Schema: ai.Schema{Type: "array", Items: &ai.Schema{Type: "string"}}})
+	if req := c.createRequest(nil, ai.Text("Hello")); req.ToolChoice.OfTool == nil || len(req.Tools) != 1 {
+		t.Errorf("expected forced JSON tool; got %v", req.ToolChoice)
+	}
+	c.SetThinking(true)
+	req := c.createRequest(nil, ai.Text("Hello"))
+	if len(req.Tools) != 0 || !param.IsOmitted(req.ToolChoice) {
+		t.Errorf("expected no JSON tool with thinking; got %v", req.Tools)
+	}
+	if schema := req.OutputConfig.Format.Schema; schema["type"] != "array" {
+		t.Errorf("expected array output format; got %v", schema)
+	}
+	if _, err := c.wait(t.Context(), nil); err != nil {
+		t.Errorf("expected no error with schema; got %v", err)
+	}
+	c.SetJSONResponse(true, nil)
+	if _, err := c.wait(t.Context(), nil); err == nil {
+		t.Error("expected error for JSON response without schema with thinking; got nil")
+	}
+}
+
+func TestSystemInstruction(t *testing.T) {
+	c := NewWithClient(anthropic.NewClient(), "").(*Anthropic)
+	c.SetSystemInstruction(ai.Text("You are a cat."), ai.Text("Answer briefly."))
+	req := c.createRequest(nil, ai.Text("Hello"))
+	if n := len(req.System); n != 2 {
+		t.Fatalf("expected 2 system blocks; got %d", n)
+	}
+	if req.System[0].Text != "You are a cat." {
+		t.Errorf("unexpected system block: %q", req.System[0].Text)
+	}
+}
+
+func TestThinkingBudget(t *testing.T) {
+	c := NewWithClient(anthropic.NewClient(), "").(*Anthropic)
+	for i, tc := range []struct {
+		maxTokens int64
+		cfg       ai.ThinkingConfig
+		budget    int64
+	}{
+		{4096, ai.ThinkingConfig{Budget: 100}, MinThinkingBudget},
+		{4096, ai.ThinkingConfig{Budget: 2048}, 2048},
+		{4096, ai.ThinkingConfig{Effort: ai.ThinkingHigh}, 4095},
+		{2000, ai.ThinkingConfig{Budget: 50000}, 1999},
+	} {
+		c.SetMaxTokens(tc.maxTokens)
+		c.SetThinkingConfig(tc.cfg)
+		if budget := c.createRequest(nil, ai.Text("Hello")).Thinking.OfEnabled.BudgetTokens; budget != tc.budget {
+			t.Errorf("#%d: expected budget %d; got %d", i, tc.budget, budget)
+		}
+	}
+	c.SetMaxTokens(MinThinkingBudget)
+	if _, err := c.wait(t.Context(), nil); err == nil {
+		t.Error("expected error for max tokens below the minimum thinking budget; got nil")
+	}
+	c.SetThinking(false)
+	if _, err := c.wait(t.Context(), nil); err != nil {
+		t.Errorf("expected no error without thinking; got %v", err)
+	}
+}
+
+func TestSetHistory(t *testing.T) {
+	history := []ai.Content{
+		{Role: "user", Parts: []ai.Part{ai.Text("What is it?"), ai.ImageData("image/png", []byte{1, 2, 3})}},
+		{Role: "assistant", Parts: []ai.Part{
+			ai.Text("Let me check."),
+			ai.FunctionCall{ID: "1", Name: "describe", Arguments: `{"detail":true}`},
+		}},
+		{Role: "user", Parts: []ai.Part{ai.FunctionResponse{ID: "1", Response: `{"result":"a cat"}`}}},
+	}
+	session := NewWithClient(anthropic.NewClient(), "").ChatSession()
+	if err := session.SetHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if res := session.History(); !reflect.DeepEqual(history, res) {
+		t.Errorf("expected %v; got %v", history, res)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := aitest.NewAnthropicServer(
+		aitest.Reply{Results: []string{"Hello"}, TokenCount: ai.TokenCount{Prompt: 10, Result: 4, Total: 14, Cached: 6, CacheCreation: 2, Reasoning: 1}},
+		aitest.Reply{Chunks: []aitest.Reply{
+			{Thoughts: []string{"Weather "}, TokenCount: ai.TokenCount{Prompt: 8, Result: 5, Total: 13, Cached: 3, Reasoning: 2}},
+			{Thoughts: []string{"tool."}},
+			{Results: []string{"Let me "}},
+			{Results: []string{"check."}},
+			{FunctionCalls: []ai.FunctionCall{{ID: "toolu_1", Name: "weather", Arguments: `{"city":`}}},
+			{FunctionCalls: []ai.FunctionCall{{Arguments: `"Paris"}`}}},
+		}},
+		aitest.Error(&aitest.StatusError{StatusCode: 429, Code: "rate_limit_error", Message: "slow down"}),
+	)
+	defer s.Close()
+	c, err := New(ai.WithAPIKey("test"), ai.WithEndpoint(s.URL), ai.WithModel("test"), ai.WithRetry(ai.RetryPolicy{MaxRetries: -1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetMaxTokens(1024)
+	resp, err := c.Chat(t.Context(), ai.Text("Hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"Hello"}) {
+		t.Errorf("expected [Hello]; got %q", res)
+	}
+	if tokens, expect := resp.TokenCount(), (ai.TokenCount{Prompt: 10, Result: 4, Total: 14, Cached: 6, CacheCreation: 2, Reasoning: 1}); tokens != expect {
+		t.Errorf("expected %+v; got %+v", expect, tokens)
+	}
+
+	session := c.ChatSession()
+	stream, err := session.ChatStream(t.Context(), ai.Text("Weather in Paris?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var thoughts []string
+	var tokens ai.TokenCount
+	for {
+		resp, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		thoughts = append(thoughts, resp.Thoughts()...)
+		if resp.TokenCount().Total > 0 {
+			tokens = resp.TokenCount()
+		}
+	}
+	if expect := []string{"Weather ", "tool."}; !reflect.DeepEqual(thoughts, expect) {
+		t.Errorf("expected %q; got %q", expect, thoughts)
+	}
+	if expect := (ai.TokenCount{Prompt: 8, Result: 5, Total: 13, Cached: 3, Reasoning: 2}); tokens != expect {
+		t.Errorf("expected %+v; got %+v", expect, tokens)
+	}
+	history := session.History()
+	if n := len(history); n != 2 {
+		t.Fatalf("expected 2 contents; got %d", n)
+	}
+	if thought, ok := history[1].Parts[0].(ai.Thought); !ok || thought.Text != "Weather tool." || len(thought.Signature) == 0 {
+		t.Errorf("expected signed thought; got %v", history[1].Parts[0])
+	}
+	expect := []ai.Part{ai.Text("Let me check."), ai.FunctionCall{ID: "toolu_1", Name: "weather", Arguments: `{"city":"Paris"}`}}
+	if !reflect.DeepEqual(history[1].Parts[1:], expect) {
+		t.Errorf("expected %v; got %v", expect, history[1].Parts[1:])
+	}
+
+	_, err = c.Chat(t.Context(), ai.Text("Hi"))
+	if info, ok := ai.ClassifyError(err); !ok || info.StatusCode != 429 {
+		t.Errorf("expected 429 error; got %v", err)
+	}
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("expected 3 requests; got %d", n)
+	}
+}
+
+func TestThinkingHistory(t *testing.T) {
+	s := aitest.NewAnthropicServer(
+		aitest.Reply{Thoughts: []string{"Use the weather tool."}, FunctionCalls: []ai.FunctionCall{{ID: "toolu_1", Name: "weather", Arguments: `{"city":"Paris"}`}}},
+		aitest.Text("Sunny"),
+	)
+	defer s.Close()
+	c, err := New(ai.WithAPIKey("test"), ai.WithEndpoint(s.URL), ai.WithModel("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetMaxTokens(4096)
+	c.SetThinking(true)
+	session := c.ChatSession()
+	if _, err := session.Chat(t.Context(), ai.Text("Weather in Paris?")); err != nil {
+		t.Fatal(err)
+	}
+	history := session.History()
+	thought, ok := history[1].Parts[0].(ai.Thought)
+	if !ok || len(thought.Signature) == 0 {
+		t.Fatalf("expected signed thought; got %v", history[1].Parts[0])
+	}
+	history[1].Parts = append([]ai.Part{ai.Thought{Signature: []byte("data"), Redacted: true, Provider: ai.Anthropic}}, history[1].Parts...)
+
+	restored := c.ChatSession()
+	if err := restored.SetHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Chat(t.Context(), ai.FunctionResponse{ID: "toolu_1", Response: `{"weather":"sunny"}`}); err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		Messages []struct {
+			Role    string
+			Content []map[string]any
+		}
+	}
+	if err := s.Requests()[1].JSON(&req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(req.Messages); n != 3 {
+		t.Fatalf("expected 3 messages; got %d", n)
+	}
+	content := req.Messages[1].Content
+	if content[0]["type"] != "redacted_thinking" || content[0]["data"] != "data" {
+		t.Errorf("expected redacted thinking block; got %v", content[0])
+	}
+	if content[1]["type"] != "thinking" || content[1]["thinking"] != thought.Text || content[1]["signature"] != string(thought.Signature) {
+		t.Errorf("expected thinking block %v; got %v", thought, content[1])
+	}
+	if content[2]["type"] != "tool_use" {
+		t.Errorf("expected tool use block; got %v", content[2])
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	s := aitest.NewAnthropicServer()
+	defer s.Close()
+	c, err := New(ai.WithAPIKey("test"), ai.WithEndpoint(s.URL), ai.WithModel("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.CountTokens(t.Context(), ai.Text("Hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 tokens; got %d", n)
+	}
+	var req struct{ Model string }
+	if err := s.Requests()[0].JSON(&req); err != nil {
+		t.Fatal(err)
+	} else if req.Model != "test" {
+		t.Errorf("expected model test; got %q", req.Model)
+	}
+}
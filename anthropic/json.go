@@ -0,0 +1,97 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/sunshineplan/ai"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const (
+	defaultJSONToolName = "json_response"
+	jsonWrapperKey      = "response"
+)
+
+var invalidToolName = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// jsonTool is a synthetic tool whose input schema is the requested JSON schema.
+// Forcing the model to call it is how JSON responses are enforced.
+// As forced tool use is not compatible with extended thinking or other tools,
+// the schema is then given as the native output format instead.
+type jsonTool struct {
+	tool anthropic.ToolParam
+	// wrapped is set when the schema is not an object and has been
+	// wrapped in an object under jsonWrapperKey, as tool input must be an object.
+	wrapped bool
+	// format is the output format of the schema, which is unset without a schema.
+	format anthropic.JSONOutputFormatParam
+}
+
+func newJSONTool(schema *ai.JSONSchema) (*jsonTool, error) {
+	t := &jsonTool{tool: anthropic.ToolParam{
+		Name:        defaultJSONToolName,
+		Description: anthropic.String("Respond with JSON using this tool."),
+	}}
+	if schema == nil {
+		t.tool.InputSchema = anthropic.ToolInputSchemaParam{}
+		return t, nil
+	}
+	if name := invalidToolName.ReplaceAllString(schema.Name, "_"); name != "" {
+		if len(name) > 64 {
+			name = name[:64]
+		}
+		t.tool.Name = name
+	}
+	if schema.Description != "" {
+		t.tool.Description = anthropic.String(schema.Description)
+	}
+	b, err := json.Marshal(schema.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: JSON schema %q: %w", schema.Name, err)
+	}
+	if err := json.Unmarshal(b, &t.format.Schema); err != nil {
+		return nil, fmt.Errorf("anthropic: JSON schema %q: %w", schema.Name, err)
+	}
+	s := schema.Schema
+	if s.Type != "object" || s.Nullable {
+		s = ai.Schema{
+			Type:       "object",
+			Properties: map[string]*ai.Schema{jsonWrapperKey: &schema.Schema},
+			Required:   []string{jsonWrapperKey},
+		}
+		t.wrapped = true
+	}
+	if t.tool.InputSchema, err = toolInputSchema(s); err != nil {
+		return nil, fmt.Errorf("anthropic: JSON schema %q: %w", schema.Name, err)
+	}
+	return t, nil
+}
+
+func (t *jsonTool) result(input json.RawMessage) string {
+	if !t.wrapped {
+		return string(input)
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(input, &m); err != nil {
+		return string(input)
+	}
+	return string(m[jsonWrapperKey])
+}
+
+// toParam converts the message to a history entry, replacing the synthetic
+// tool use with its JSON result so that the conversation can continue.
+func (t *jsonTool) toParam(msg *anthropic.Message) anthropic.MessageParam {
+	p := msg.ToParam()
+	if t == nil {
+		return p
+	}
+	for i, block := range msg.Content {
+		if v, ok := block.AsAny().(anthropic.ToolUseBlock); ok && v.Name == t.tool.Name {
+			p.Content[i] = anthropic.NewTextBlock(t.result(v.Input))
+		}
+	}
+	return p
+}
@@ -0,0 +1,270 @@
+package ai
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CacheStore stores cached responses by key.
+type CacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+type bypassCacheKey struct{}
+
+// BypassCache returns a context whose calls neither read from nor write to the cache.
+func BypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+func bypassCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return bypass
+}
+
+var _ ChatResponse = new(CachedResponse)
+
+// CachedResponse is a response served from the cache.
+type CachedResponse struct {
+	results  []string
+	thoughts []string
+	calls    []FunctionCall
+	tokens   TokenCount
+}
+
+func (*CachedResponse) Raw() any                           { return nil }
+func (resp *CachedResponse) Results() []string             { return resp.results }
+func (resp *CachedResponse) Thoughts() []string            { return resp.thoughts }
+func (resp *CachedResponse) FunctionCalls() []FunctionCall { return resp.calls }
+
+// TokenCount returns the token usage of the original response.
+func (resp *CachedResponse) TokenCount() TokenCount { return resp.tokens }
+
+// IsCacheHit reports whether the response was served from the cache.
+func IsCacheHit(resp ChatResponse) bool {
+	_, ok := resp.(*CachedResponse)
+	return ok
+}
+
+type cacheEntry struct {
+	Expires       time.Time      `json:"expires,omitzero"`
+	Results       []string       `json:"results,omitempty"`
+	Thoughts      []string       `json:"thoughts,omitempty"`
+	FunctionCalls []FunctionCall `json:"function_calls,omitempty"`
+	TokenCount    TokenCount     `json:"token_count"`
+}
+
+// Cached returns an AI whose Chat calls are cached in store for ttl, or forever if ttl is zero.
+// Responses are keyed on the model, the model settings and the input parts.
+// Streams, sessions and embeddings are not cached.
+//
+// Only model settings applied through the returned AI are part of the key, so ai must be wrapped
+// before it is configured, for example created without ClientConfig.ModelConfig, which is then
+// applied with ApplyModelConfig(Cached(ai, store, ttl), cfg). Otherwise clients configured
+// differently may share entries in one store.
+func Cached(ai AI, store CacheStore, ttl time.Duration) AI {
+	return withEmbedder(&cachedAI{AI: ai, store: store, ttl: ttl, settings: make(map[string]any)}, ai)
+}
+
+type cachedAI struct {
+	AI
+	store CacheStore
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	settings map[string]any
+}
+
+func (ai *cachedAI) set(name string, value any) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.settings[name] = value
+}
+
+func (ai *cachedAI) key(parts []Part) (string, error) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	b, err := json.Marshal(struct {
+		LLMs     LLMs           `json:"llms"`
+		Model    string         `json:"model"`
+		Settings map[string]any `json:"settings"`
+		Input    Content        `json:"input"`
+	}{ai.LLMs(), ai.Model(), ai.settings, Content{Parts: parts}})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (ai *cachedAI) SetSystemInstruction(parts ...Part) {
+	ai.set("system", Content{Parts: parts})
+	ai.AI.SetSystemInstruction(parts...)
+}
+func (ai *cachedAI) SetFunctionCall(f []Function, mode FunctionCallingMode) {
+	ai.set("tools", []any{f, mode})
+	ai.AI.SetFunctionCall(f, mode)
+}
+func (ai *cachedAI) SetCount(x int64) {
+	ai.set("count", x)
+	ai.AI.SetCount(x)
+}
+func (ai *cachedAI) SetMaxTokens(x int64) {
+	ai.set("max_tokens", x)
+	ai.AI.SetMaxTokens(x)
+}
+func (ai *cachedAI) SetTemperature(x float64) {
+	ai.set("temperature", x)
+	ai.AI.SetTemperature(x)
+}
+func (ai *cachedAI) SetTopP(x float64) {
+	ai.set("top_p", x)
+	ai.AI.SetTopP(x)
+}
+func (ai *cachedAI) SetJSONResponse(set bool, schema *JSONSchema) {
+	ai.set("json", []any{set, schema})
+	ai.AI.SetJSONResponse(set, schema)
+}
+func (ai *cachedAI) SetThinking(set bool) {
+	ai.set("thinking", set)
+	ai.AI.SetThinking(set)
+}
+func (ai *cachedAI) SetThinkingConfig(cfg ThinkingConfig) {
+	ai.set("thinking", cfg)
+	ai.AI.SetThinkingConfig(cfg)
+}
+
+func (ai *cachedAI) Chat(ctx context.Context, parts ...Part) (ChatResponse, error) {
+	if bypassCache(ctx) {
+		return ai.AI.Chat(ctx, parts...)
+	}
+	key, err := ai.key(parts)
+	if err != nil {
+		return ai.AI.Chat(ctx, parts...)
+	}
+	if b, ok := ai.store.Get(key); ok {
+		var entry cacheEntry
+		if err := json.Unmarshal(b, &entry); err == nil && (entry.Expires.IsZero() || time.Now().Before(entry.Expires)) {
+			return &CachedResponse{entry.Results, entry.Thoughts, entry.FunctionCalls, entry.TokenCount}, nil
+		}
+		ai.store.Delete(key)
+	}
+	resp, err := ai.AI.Chat(ctx, parts...)
+	if err != nil {
+		return nil, err
+	}
+	entry := cacheEntry{
+		Results:       resp.Results(),
+		Thoughts:      resp.Thoughts(),
+		FunctionCalls: resp.FunctionCalls(),
+		TokenCount:    resp.TokenCount(),
+	}
+	if ai.ttl > 0 {
+		entry.Expires = time.Now().Add(ai.ttl)
+	}
+	if b, err := json.Marshal(entry); err == nil {
+		ai.store.Set(key, b)
+	}
+	return resp, nil
+}
+
+// NewMemoryCache returns an in-memory CacheStore which keeps at most size entries,
+// evicting the least recently used ones.
+func NewMemoryCache(size int) CacheStore {
+	return &memoryCache{size: size, list: list.New(), items: make(map[string]*list.Element)}
+}
+
+type memoryCache struct {
+	mu    sync.Mutex
+	size  int
+	list  *list.List
+	items map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.list.MoveToFront(e)
+	return e.Value.(*memoryCacheItem).value, true
+}
+
+func (c *memoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*memoryCacheItem).value = value
+		c.list.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.list.PushFront(&memoryCacheItem{key, value})
+	for c.size > 0 && c.list.Len() > c.size {
+		e := c.list.Back()
+		c.list.Remove(e)
+		delete(c.items, e.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (c *memoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.list.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// NewFileCache returns a CacheStore which keeps each entry in a file in dir.
+func NewFileCache(dir string) (CacheStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return fileCache(dir), nil
+}
+
+type fileCache string
+
+func (c fileCache) path(key string) string {
+	return filepath.Join(string(c), key+".json")
+}
+
+func (c fileCache) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(c.path(key))
+	return b, err == nil
+}
+
+func (c fileCache) Set(key string, value []byte) {
+	f, err := os.CreateTemp(string(c), key+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if err = errors.Join(err, f.Close()); err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+func (c fileCache) Delete(key string) {
+	os.Remove(c.path(key))
+}
@@ -0,0 +1,80 @@
+package ai_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sunshineplan/ai"
+	"github.com/sunshineplan/ai/aitest"
+)
+
+func TestCached(t *testing.T) {
+	dir, err := ai.NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []ai.CacheStore{ai.NewMemoryCache(10), dir} {
+		f := aitest.New(aitest.Text("test"), aitest.Text("test"), aitest.Text("test"))
+		f.SetModel("model")
+		c := ai.Cached(f, store, time.Hour)
+		for range 2 {
+			if _, err := c.Chat(context.Background(), ai.Text("hello")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp, err := c.Chat(context.Background(), ai.Text("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ai.IsCacheHit(resp) || resp.Results()[0] != "test" {
+			t.Errorf("expected cache hit; got %#v", resp)
+		}
+		if n := len(f.Calls()); n != 1 {
+			t.Errorf("expected 1 call; got %d", n)
+		}
+		c.SetTemperature(0.5)
+		if resp, _ := c.Chat(context.Background(), ai.Text("hello")); ai.IsCacheHit(resp) {
+			t.Error("expected cache miss after settings change")
+		}
+		if resp, _ := c.Chat(ai.BypassCache(context.Background()), ai.Text("hello")); ai.IsCacheHit(resp) {
+			t.Error("expected cache bypass")
+		}
+		if n := len(f.Calls()); n != 3 {
+			t.Errorf("expected 3 calls; got %d", n)
+		}
+	}
+
+	store := ai.NewMemoryCache(1)
+	store.Set("a", []byte("a"))
+	store.Set("b", []byte("b"))
+	if _, ok := store.Get("a"); ok {
+		t.Error("expected a evicted")
+	}
+}
+
+func TestCachedModelConfig(t *testing.T) {
+	store := ai.NewMemoryCache(10)
+	var clients []ai.AI
+	for _, system := range []string{"You are a cat.", "You are a dog."} {
+		c := ai.Cached(aitest.New(aitest.Text(system)), store, 0)
+		ai.ApplyModelConfig(c, ai.ModelConfig{SystemInstruction: system})
+		clients = append(clients, c)
+	}
+	for _, c := range clients {
+		resp, err := c.Chat(context.Background(), ai.Text("Who are you?"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ai.IsCacheHit(resp) {
+			t.Errorf("expected cache miss; got %q", resp.Results())
+		}
+	}
+	resp, err := clients[1].Chat(context.Background(), ai.Text("Who are you?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ai.IsCacheHit(resp) || resp.Results()[0] != "You are a dog." {
+		t.Errorf("expected cached dog reply; got %q", resp.Results())
+	}
+}
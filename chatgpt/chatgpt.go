@@ -3,31 +3,61 @@ package chatgpt
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math"
-	"net/http"
-	"net/url"
+	"slices"
 	"strings"
-	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/sunshineplan/ai"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/packages/respjson"
 	"github.com/openai/openai-go/packages/ssestream"
 	"github.com/openai/openai-go/shared"
-	"golang.org/x/time/rate"
 )
 
-const defaultModel = openai.ChatModelGPT4oMini
+const (
+	defaultModel          = openai.ChatModelGPT4oMini
+	defaultEmbeddingModel = openai.EmbeddingModelTextEmbedding3Small
+)
+
+// EmbeddingBatchSize is the maximum number of texts sent in one embeddings request.
+var EmbeddingBatchSize = 2048
 
-var _ ai.AI = new(ChatGPT)
+var (
+	_ ai.AI       = new(ChatGPT)
+	_ ai.Embedder = new(ChatGPT)
+)
+
+func init() {
+	ai.Register(ai.ChatGPT, func(_ context.Context, opts ...ai.ClientOption) (ai.AI, error) {
+		return New(opts...)
+	})
+	ai.Register(ai.OpenAICompatible, func(_ context.Context, opts ...ai.ClientOption) (ai.AI, error) {
+		return NewCompatible(opts...)
+	})
+	ai.RegisterErrorClassifier(func(err error) (info ai.ErrorInfo, ok bool) {
+		var e *openai.Error
+		if ok = errors.As(err, &e); ok {
+			info.StatusCode = e.StatusCode
+			info.QuotaExceeded = e.Code == "insufficient_quota"
+			if e.Response != nil {
+				info.RetryAfter = ai.RetryAfter(e.Response.Header)
+			}
+		}
+		return
+	})
+}
 
 type ChatGPT struct {
 	*openai.Client
 	model       string
+	system      []openai.ChatCompletionContentPartTextParam
 	toolChoice  openai.ChatCompletionToolChoiceOptionUnionParam
 	tools       []openai.ChatCompletionToolParam
 	maxTokens   *int64
@@ -35,9 +65,17 @@ type ChatGPT struct {
 	topP        *float64
 	count       *int64
 	json        openai.ChatCompletionNewParamsResponseFormatUnion
-	thinking    bool
+	thinking    *ai.ThinkingConfig
+	embedding   ai.EmbeddingConfig
+
+	systemErr error
+	toolsErr  error
+	schemaErr error
 
-	limiter *rate.Limiter
+	// compatible reports whether the server is an OpenAI-compatible one rather than OpenAI.
+	compatible bool
+
+	ai.Limits
 }
 
 func New(opts ...ai.ClientOption) (ai.AI, error) {
@@ -45,28 +83,61 @@ func New(opts ...ai.ClientOption) (ai.AI, error) {
 	for _, i := range opts {
 		i.Apply(cfg)
 	}
+	return newAI(cfg, false)
+}
+
+// NewCompatible returns an AI for a self-hosted server speaking the OpenAI chat completions API.
+// The endpoint and model are required, and the API key is optional.
+//
+// Requests leave out the settings such servers commonly reject: the count is ignored,
+// JSON schema responses are downgraded to JSON object responses and thinking effort is not sent.
+func NewCompatible(opts ...ai.ClientOption) (ai.AI, error) {
+	cfg := new(ai.ClientConfig)
+	for _, i := range opts {
+		i.Apply(cfg)
+	}
+	if cfg.Endpoint == "" {
+		return nil, errors.New("chatgpt: endpoint is required for OpenAI-compatible server")
+	}
+	if cfg.Model == "" {
+		return nil, errors.New("chatgpt: model is required for OpenAI-compatible server")
+	}
+	return newAI(cfg, true)
+}
+
+func newAI(cfg *ai.ClientConfig, compatible bool) (ai.AI, error) {
 	options := []option.RequestOption{
 		option.WithAPIKey(cfg.APIKey),
 	}
+	if compatible {
+		// Don't send the OpenAI credentials picked up from the environment.
+		options = append(options, option.WithHeaderDel("OpenAI-Organization"), option.WithHeaderDel("OpenAI-Project"))
+		if cfg.APIKey == "" {
+			options = append(options, option.WithHeaderDel("Authorization"))
+		}
+	}
 	if cfg.Endpoint != "" {
 		options = append(options, option.WithBaseURL(cfg.Endpoint))
 	}
-	if cfg.Proxy != "" {
-		u, err := url.Parse(cfg.Proxy)
-		if err != nil {
-			return nil, err
-		}
-		if t, ok := http.DefaultTransport.(*http.Transport); ok {
-			t = t.Clone()
-			t.Proxy = http.ProxyURL(u)
-			options = append(options, option.WithHTTPClient(&http.Client{Transport: t}))
-		}
+	client, err := ai.NewHTTPClient(*cfg)
+	if err != nil {
+		return nil, err
 	}
-	c := NewWithClient(openai.NewClient(options...), cfg.Model)
-	if cfg.Limit != nil {
-		c.SetLimit(*cfg.Limit)
+	if client != nil {
+		options = append(options, option.WithHTTPClient(client))
+	}
+	if cfg.Retry != nil {
+		// Leave retrying to the policy.
+		options = append(options, option.WithMaxRetries(0))
 	}
+	c := NewWithClient(openai.NewClient(options...), cfg.Model)
+	c.(*ChatGPT).compatible = compatible
+	c.(*ChatGPT).SetEmbeddingConfig(cfg.Embedding)
+	ai.ApplyLimits(c, *cfg)
 	ai.ApplyModelConfig(c, cfg.ModelConfig)
+	if cfg.Retry != nil {
+		return ai.Retry(c, *cfg.Retry), nil
+	}
 	return c, nil
 }
 
@@ -77,7 +148,10 @@ func NewWithClient(client openai.Client, model string) ai.AI {
 	return &ChatGPT{Client: &client, model: model}
 }
 
-func (ChatGPT) LLMs() ai.LLMs {
+func (chatgpt *ChatGPT) LLMs() ai.LLMs {
+	if chatgpt.compatible {
+		return ai.OpenAICompatible
+	}
 	return ai.ChatGPT
 }
 
@@ -85,34 +159,58 @@ func (chatgpt *ChatGPT) Model() string {
 	return chatgpt.model
 }
 
-func (chatgpt *ChatGPT) SetLimit(rpm int64) {
-	chatgpt.limiter = ai.NewLimiter(rpm)
+// wait acquires the limits for a request, estimating the tokens of the system instruction,
+// the history and the parts.
+func (chatgpt *ChatGPT) wait(
+	ctx context.Context,
+	history []openai.ChatCompletionMessageParamUnion,
+	parts ...ai.Part,
+) (ai.Release, error) {
+	if err := errors.Join(chatgpt.systemErr, chatgpt.toolsErr, chatgpt.schemaErr); err != nil {
+		return nil, err
+	}
+	tokens := ai.EstimateTokens(parts...) + ai.EstimateHistory((&ChatSession{ai: chatgpt, history: history}).History())
+	for _, i := range chatgpt.system {
+		tokens += ai.EstimateTokens(ai.Text(i.Text))
+	}
+	return chatgpt.Acquire(ctx, tokens)
 }
 
-func (chatgpt *ChatGPT) Limit() (rpm int64) {
-	if chatgpt.limiter == nil {
-		return math.MaxInt64
+func (ai *ChatGPT) SetModel(model string) { ai.model = model }
+func (chatgpt *ChatGPT) SetSystemInstruction(parts ...ai.Part) {
+	chatgpt.system, chatgpt.systemErr = nil, nil
+	for _, i := range parts {
+		if v, ok := i.(ai.Text); ok {
+			chatgpt.system = append(chatgpt.system, openai.ChatCompletionContentPartTextParam{Text: string(v)})
+		} else {
+			chatgpt.systemErr = fmt.Errorf("chatgpt: unsupported system instruction part %T", i)
+		}
 	}
-	return int64(chatgpt.limiter.Limit() / rate.Every(time.Minute))
 }
-
-func (ai *ChatGPT) wait(ctx context.Context) error {
-	if ai.limiter != nil {
-		return ai.limiter.Wait(ctx)
+func toMap(schema ai.Schema) (map[string]any, error) {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func (ai *ChatGPT) SetModel(model string) { ai.model = model }
 func (chatgpt *ChatGPT) SetFunctionCall(f []ai.Function, mode ai.FunctionCallingMode) {
+	chatgpt.toolsErr = nil
 	if chatgpt.tools = nil; len(f) == 0 {
 		chatgpt.toolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{}
 		return
 	}
 	for _, i := range f {
-		var parameters openai.FunctionParameters
-		b, _ := json.Marshal(i.Parameters)
-		_ = json.Unmarshal(b, &parameters)
+		parameters, err := toMap(i.Parameters)
+		if err != nil {
+			chatgpt.toolsErr = errors.Join(chatgpt.toolsErr, fmt.Errorf("chatgpt: function %q: %w", i.Name, err))
+			continue
+		}
 		chatgpt.tools = append(chatgpt.tools, openai.ChatCompletionToolParam{
 			Function: openai.FunctionDefinitionParam{
 				Name:        i.Name,
@@ -136,11 +234,13 @@ func (ai *ChatGPT) SetTemperature(f float64) { ai.temperature = &f }
 func (ai *ChatGPT) SetTopP(f float64)        { ai.topP = &f }
 func (ai *ChatGPT) SetJSONResponse(set bool, schema *ai.JSONSchema) {
 	var responseFormat openai.ChatCompletionNewParamsResponseFormatUnion
+	ai.schemaErr = nil
 	if set {
 		if schema != nil {
-			var format any
-			b, _ := json.Marshal(schema.Schema)
-			_ = json.Unmarshal(b, &format)
+			format, err := toMap(schema.Schema)
+			if err != nil {
+				ai.schemaErr = fmt.Errorf("chatgpt: JSON schema %q: %w", schema.Name, err)
+			}
 			responseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
 				OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
 					JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
@@ -158,7 +258,14 @@ func (ai *ChatGPT) SetJSONResponse(set bool, schema *ai.JSONSchema) {
 	}
 	ai.json = responseFormat
 }
-func (ai *ChatGPT) SetThinking(set bool) { ai.thinking = set }
+func (chatgpt *ChatGPT) SetThinking(set bool) {
+	if set {
+		chatgpt.SetThinkingConfig(ai.ThinkingConfig{})
+	} else {
+		chatgpt.thinking = nil
+	}
+}
+func (ai *ChatGPT) SetThinkingConfig(cfg ai.ThinkingConfig) { ai.thinking = &cfg }
 
 func (ai *ChatGPT) ListModels(ctx context.Context) ([]string, error) {
 	iter := ai.Client.Models.ListAutoPaging(ctx)
@@ -173,6 +280,59 @@ func (ai *ChatGPT) ListModels(ctx context.Context) ([]string, error) {
 	return res, nil
 }
 
+func (ai *ChatGPT) SetEmbeddingConfig(cfg ai.EmbeddingConfig) { ai.embedding = cfg }
+
+// Embed creates embeddings with the embeddings API. The task of the config is not supported and ignored.
+func (chatgpt *ChatGPT) Embed(ctx context.Context, texts ...string) ([][]float32, ai.TokenCount, error) {
+	if chatgpt.Client == nil {
+		return nil, ai.TokenCount{}, ai.ErrAIClosed
+	}
+	model := chatgpt.embedding.Model
+	if model == "" {
+		if chatgpt.compatible {
+			return nil, ai.TokenCount{}, errors.New("chatgpt: embedding model is required for OpenAI-compatible server")
+		}
+		model = defaultEmbeddingModel
+	}
+	return ai.EmbedBatches(ctx, texts, EmbeddingBatchSize, func(ctx context.Context, batch []string) ([][]float32, ai.TokenCount, error) {
+		var parts []ai.Part
+		for _, i := range batch {
+			parts = append(parts, ai.Text(i))
+		}
+		release, err := chatgpt.Acquire(ctx, ai.EstimateTokens(parts...))
+		if err != nil {
+			return nil, ai.TokenCount{}, err
+		}
+		var usage ai.TokenCount
+		defer func() { release.Done(usage) }()
+		params := openai.EmbeddingNewParams{
+			Input:          openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: batch},
+			Model:          model,
+			EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+		}
+		if chatgpt.embedding.Dimensions > 0 {
+			params.Dimensions = openai.Int(chatgpt.embedding.Dimensions)
+		}
+		resp, err := chatgpt.Client.Embeddings.New(ctx, params)
+		if err != nil {
+			return nil, ai.TokenCount{}, err
+		}
+		usage = ai.TokenCount{Prompt: resp.Usage.PromptTokens, Total: resp.Usage.TotalTokens}
+		vectors := make([][]float32, len(batch))
+		for _, i := range resp.Data {
+			if i.Index < 0 || int(i.Index) >= len(vectors) {
+				return nil, usage, fmt.Errorf("chatgpt: embedding index %d out of range", i.Index)
+			}
+			vector := make([]float32, len(i.Embedding))
+			for n, v := range i.Embedding {
+				vector[n] = float32(v)
+			}
+			vectors[i.Index] = vector
+		}
+		return vectors, usage, nil
+	})
+}
+
 var _ ai.ChatResponse = new(ChatResponse[*openai.ChatCompletion])
 
 type ChatCompletionResponse interface {
@@ -205,8 +365,36 @@ func (resp *ChatResponse[Response]) Results() (res []string) {
 	return
 }
 
+// reasoningFields lists the fields used by OpenAI-compatible servers for reasoning content.
+var reasoningFields = []string{"reasoning_content", "reasoning"}
+
+func reasoning(extra map[string]respjson.Field) string {
+	for _, i := range reasoningFields {
+		if f, ok := extra[i]; ok {
+			var s string
+			if err := json.Unmarshal([]byte(f.Raw()), &s); err == nil && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
 func (resp *ChatResponse[Response]) Thoughts() (res []string) {
-	fmt.Println("ChatGPT doesn't support reasoning content")
+	switch v := any(resp.resp).(type) {
+	case *openai.ChatCompletion:
+		for _, i := range v.Choices {
+			if s := reasoning(i.Message.JSON.ExtraFields); s != "" {
+				res = append(res, s)
+			}
+		}
+	case openai.ChatCompletionChunk:
+		for _, i := range v.Choices {
+			if s := reasoning(i.Delta.JSON.ExtraFields); s != "" {
+				res = append(res, s)
+			}
+		}
+	}
 	return
 }
 
@@ -228,18 +416,24 @@ func (resp *ChatResponse[Response]) FunctionCalls() (res []ai.FunctionCall) {
 	return
 }
 
-func (resp *ChatResponse[Response]) TokenCount() (res ai.TokenCount) {
+func (resp *ChatResponse[Response]) TokenCount() ai.TokenCount {
 	switch v := any(resp.resp).(type) {
 	case *openai.ChatCompletion:
-		res.Prompt = v.Usage.PromptTokens
-		res.Result = v.Usage.CompletionTokens
-		res.Total = v.Usage.TotalTokens
+		return tokenCount(v.Usage)
 	case openai.ChatCompletionChunk:
-		res.Prompt = v.Usage.PromptTokens
-		res.Result = v.Usage.CompletionTokens
-		res.Total = v.Usage.TotalTokens
+		return tokenCount(v.Usage)
+	}
+	return ai.TokenCount{}
+}
+
+func tokenCount(usage openai.CompletionUsage) ai.TokenCount {
+	return ai.TokenCount{
+		Prompt:    usage.PromptTokens,
+		Result:    usage.CompletionTokens,
+		Total:     usage.TotalTokens,
+		Cached:    usage.PromptTokensDetails.CachedTokens,
+		Reasoning: usage.CompletionTokensDetails.ReasoningTokens,
 	}
-	return
 }
 
 func (resp *ChatResponse[Response]) String() string {
@@ -281,7 +475,7 @@ func (c *ChatGPT) createRequest(
 	if c.maxTokens != nil {
 		req.MaxTokens = openai.Int(*c.maxTokens)
 	}
-	if !one && c.count != nil {
+	if !one && !c.compatible && c.count != nil {
 		req.N = openai.Int(*c.count)
 	}
 	if c.temperature != nil {
@@ -290,13 +484,25 @@ func (c *ChatGPT) createRequest(
 	if c.topP != nil {
 		req.TopP = openai.Float(*c.topP)
 	}
-	if c.json.OfJSONObject != nil || c.json.OfJSONSchema != nil {
+	if c.compatible && c.json.OfJSONSchema != nil {
+		req.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{OfJSONObject: &openai.ResponseFormatJSONObjectParam{}}
+	} else if c.json.OfJSONObject != nil || c.json.OfJSONSchema != nil {
 		req.ResponseFormat = c.json
 	}
-	if c.thinking {
-		req.ReasoningEffort = shared.ReasoningEffortMedium
+	if c.thinking != nil && !c.compatible {
+		switch c.thinking.EffortLevel() {
+		case ai.ThinkingLow:
+			req.ReasoningEffort = shared.ReasoningEffortLow
+		case ai.ThinkingHigh:
+			req.ReasoningEffort = shared.ReasoningEffortHigh
+		default:
+			req.ReasoningEffort = shared.ReasoningEffortMedium
+		}
 	}
 	var msgs []openai.ChatCompletionMessageParamUnion
+	if len(c.system) > 0 {
+		msgs = append(msgs, openai.SystemMessage(c.system))
+	}
 	msgs = append(msgs, history...)
 	for _, i := range messages {
 		switch v := i.(type) {
@@ -324,10 +530,16 @@ func (chatgpt *ChatGPT) chat(
 		err = ai.ErrAIClosed
 		return
 	}
-	if err = chatgpt.wait(ctx); err != nil {
+	release, err := chatgpt.wait(ctx, history, messages...)
+	if err != nil {
 		return
 	}
-	return chatgpt.Client.Chat.Completions.New(ctx, chatgpt.createRequest(session, history, messages...))
+	var usage ai.TokenCount
+	defer func() { release.Done(usage) }()
+	if resp, err = chatgpt.Client.Chat.Completions.New(ctx, chatgpt.createRequest(session, history, messages...)); err == nil {
+		usage = (&ChatResponse[*openai.ChatCompletion]{resp}).TokenCount()
+	}
+	return
 }
 
 func (ai *ChatGPT) Chat(ctx context.Context, messages ...ai.Part) (ai.ChatResponse, error) {
@@ -374,22 +586,26 @@ func (chatgpt *ChatGPT) chatStream(
 	ctx context.Context,
 	history []openai.ChatCompletionMessageParamUnion,
 	messages ...ai.Part,
-) (*ssestream.Stream[openai.ChatCompletionChunk], error) {
+) (*ssestream.Stream[openai.ChatCompletionChunk], ai.Release, error) {
 	if chatgpt.Client == nil {
-		return nil, ai.ErrAIClosed
+		return nil, nil, ai.ErrAIClosed
 	}
-	if err := chatgpt.wait(ctx); err != nil {
-		return nil, err
+	release, err := chatgpt.wait(ctx, history, messages...)
+	if err != nil {
+		return nil, nil, err
 	}
-	return chatgpt.Client.Chat.Completions.NewStreaming(ctx, chatgpt.createRequest(true, history, messages...)), nil
+	req := chatgpt.createRequest(true, history, messages...)
+	// Usage is only sent in a last chunk when asked for.
+	req.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+	return chatgpt.Client.Chat.Completions.NewStreaming(ctx, req), release, nil
 }
 
 func (ai *ChatGPT) ChatStream(ctx context.Context, messages ...ai.Part) (ai.ChatStream, error) {
-	stream, err := ai.chatStream(ctx, nil, messages...)
+	stream, release, err := ai.chatStream(ctx, nil, messages...)
 	if err != nil {
 		return nil, err
 	}
-	return &ChatStream{stream: stream, session: nil}, nil
+	return release.Stream(&ChatStream{stream: stream, session: nil}), nil
 }
 
 var _ ai.ChatSession = new(ChatSession)
@@ -427,38 +643,47 @@ func (session *ChatSession) Chat(ctx context.Context, messages ...ai.Part) (ai.C
 }
 
 func (session *ChatSession) ChatStream(ctx context.Context, messages ...ai.Part) (ai.ChatStream, error) {
-	stream, err := session.ai.chatStream(ctx, session.history, messages...)
+	stream, release, err := session.ai.chatStream(ctx, session.history, messages...)
 	if err != nil {
 		return nil, err
 	}
 	session.addUserHistory(messages...)
-	return &ChatStream{stream: stream, session: session}, nil
+	return release.Stream(&ChatStream{stream: stream, session: session}), nil
 }
 
 func (session *ChatSession) History() (history []ai.Content) {
 	for _, i := range session.history {
 		if i.OfUser != nil {
+			var parts []ai.Part
 			if v := i.OfUser.Content.OfString.Value; v != "" {
-				history = append(history, ai.Content{Role: "user", Parts: []ai.Part{ai.Text(v)}})
+				parts = append(parts, ai.Text(v))
 			}
-			if len(i.OfUser.Content.OfArrayOfContentParts) > 0 {
-				var parts []ai.Part
-				for _, i := range i.OfUser.Content.OfArrayOfContentParts {
+			for _, i := range i.OfUser.Content.OfArrayOfContentParts {
+				if i.OfText != nil {
+					parts = append(parts, ai.Text(i.OfText.Text))
+				} else if i.OfImageURL != nil {
 					parts = append(parts, fromImagePart(i))
 				}
+			}
+			if len(parts) > 0 {
 				history = append(history, ai.Content{Role: "user", Parts: parts})
 			}
 		} else if i.OfAssistant != nil {
+			var parts []ai.Part
 			if v := i.OfAssistant.Content.OfString.Value; v != "" {
-				history = append(history, ai.Content{Role: "assistant", Parts: []ai.Part{ai.Text(v)}})
+				parts = append(parts, ai.Text(v))
 			}
-			if tools := i.OfAssistant.ToolCalls; len(tools) > 0 {
-				for _, i := range tools {
-					history = append(history, ai.Content{Role: "assistant", Parts: []ai.Part{
-						ai.FunctionCall{ID: i.ID, Name: i.Function.Name, Arguments: i.Function.Arguments},
-					}})
+			for _, i := range i.OfAssistant.Content.OfArrayOfContentParts {
+				if i.OfText != nil {
+					parts = append(parts, ai.Text(i.OfText.Text))
 				}
 			}
+			for _, i := range i.OfAssistant.ToolCalls {
+				parts = append(parts, ai.FunctionCall{ID: i.ID, Name: i.Function.Name, Arguments: i.Function.Arguments})
+			}
+			if len(parts) > 0 {
+				history = append(history, ai.Content{Role: "assistant", Parts: parts})
+			}
 		} else if i.OfTool != nil {
 			history = append(history, ai.Content{Role: "tool", Parts: []ai.Part{
 				ai.FunctionResponse{ID: i.OfTool.ToolCallID, Response: i.OfTool.Content.OfString.Value},
@@ -468,6 +693,143 @@ func (session *ChatSession) History() (history []ai.Content) {
 	return
 }
 
+func (session *ChatSession) SetHistory(history []ai.Content) error {
+	msgs, err := toMessages(history)
+	if err != nil {
+		return err
+	}
+	session.history = msgs
+	return nil
+}
+
+func (session *ChatSession) SnapshotHistory() func() {
+	history := slices.Clone(session.history)
+	return func() { session.history = history }
+}
+
+func toMessages(history []ai.Content) (msgs []openai.ChatCompletionMessageParamUnion, err error) {
+	for _, content := range ai.NormalizeHistory(history) {
+		switch content.Role {
+		case ai.RoleAssistant:
+			var msg openai.ChatCompletionAssistantMessageParam
+			var text strings.Builder
+			for _, i := range content.Parts {
+				switch v := i.(type) {
+				case ai.Text:
+					text.WriteString(string(v))
+				case ai.FunctionCall:
+					msg.ToolCalls = append(msg.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+						ID:       v.ID,
+						Function: openai.ChatCompletionMessageToolCallFunctionParam{Name: v.Name, Arguments: v.Arguments},
+					})
+				case ai.Thought:
+					// Reasoning is not sent back to the model.
+				default:
+					return nil, fmt.Errorf("chatgpt: unsupported assistant part %T", i)
+				}
+			}
+			if text.Len() > 0 {
+				msg.Content.OfString = openai.String(text.String())
+			}
+			msgs = append(msgs, openai.ChatCompletionMessageParamUnion{OfAssistant: &msg})
+		default:
+			var parts []openai.ChatCompletionContentPartUnionParam
+			for _, i := range content.Parts {
+				switch v := i.(type) {
+				case ai.Text:
+					parts = append(parts, openai.TextContentPart(string(v)))
+				case ai.Image:
+					parts = append(parts, toImagePart(v)...)
+				case ai.Blob:
+					parts = append(parts, toImagePart(ai.ImageData(v.MIMEType, v.Data))...)
+				case ai.FunctionResponse:
+					msgs = append(msgs, openai.ToolMessage(v.Response, v.ID))
+				default:
+					return nil, fmt.Errorf("chatgpt: unsupported %s part %T", content.Role, i)
+				}
+			}
+			if len(parts) == 1 && parts[0].OfText != nil {
+				msgs = append(msgs, openai.UserMessage(parts[0].OfText.Text))
+			} else if len(parts) > 0 {
+				msgs = append(msgs, openai.UserMessage(parts))
+			}
+		}
+	}
+	return
+}
+
+// tokensPerMessage is the overhead of a chat message, including the priming of the reply.
+const tokensPerMessage = 7
+
+// CountTokens estimates the tokens of parts offline, as OpenAI has no API for it.
+func (chatgpt *ChatGPT) CountTokens(_ context.Context, parts ...ai.Part) (int64, error) {
+	if chatgpt.Client == nil {
+		return 0, ai.ErrAIClosed
+	}
+	if len(parts) == 0 {
+		return 0, nil
+	}
+	n := int64(tokensPerMessage)
+	for _, i := range parts {
+		switch v := i.(type) {
+		case ai.Text:
+			n += countText(string(v))
+		case ai.FunctionCall:
+			n += countText(v.Name) + countText(v.Arguments)
+		case ai.FunctionResponse:
+			n += countText(v.Response)
+		default:
+			n += ai.EstimateTokens(i)
+		}
+	}
+	return n, nil
+}
+
+// countText splits s into words, numbers, punctuation and whitespace like the tiktoken
+// pre-tokenizer, with a leading space joining the next piece, and counts long pieces
+// as several tokens.
+func countText(s string) (n int64) {
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		if runes[i] == ' ' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !unicode.IsNumber(runes[i+1]) {
+			i++
+		}
+		j := i + 1
+		switch r := runes[i]; {
+		case unicode.IsLetter(r):
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			var ascii, other int64
+			for _, r := range runes[i:j] {
+				if r < utf8.RuneSelf {
+					ascii++
+				} else {
+					other++
+				}
+			}
+			n += (ascii+7)/8 + other
+		case unicode.IsNumber(r):
+			for j < len(runes) && unicode.IsNumber(runes[j]) {
+				j++
+			}
+			n += int64(j-i+2) / 3
+		case unicode.IsSpace(r):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+			n++
+		default:
+			for j < len(runes) && !unicode.IsLetter(runes[j]) && !unicode.IsNumber(runes[j]) && !unicode.IsSpace(runes[j]) {
+				j++
+			}
+			n += int64(j-i+1) / 2
+		}
+		i = j
+	}
+	return
+}
+
 func (ai *ChatGPT) ChatSession() ai.ChatSession {
 	return &ChatSession{ai: ai}
 }
@@ -0,0 +1,282 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sunshineplan/ai"
+	"github.com/sunshineplan/ai/aitest"
+
+	"github.com/openai/openai-go"
+)
+
+func TestThoughts(t *testing.T) {
+	var completion openai.ChatCompletion
+	if err := json.Unmarshal([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"4","reasoning_content":"2+2=4"}}]}`), &completion); err != nil {
+		t.Fatal(err)
+	}
+	resp := &ChatResponse[*openai.ChatCompletion]{&completion}
+	if res := resp.Thoughts(); !reflect.DeepEqual(res, []string{"2+2=4"}) {
+		t.Errorf("expected [2+2=4]; got %q", res)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"4"}) {
+		t.Errorf("expected [4]; got %q", res)
+	}
+
+	var chunk openai.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(`{"choices":[{"index":0,"delta":{"reasoning":"thinking"}}]}`), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if res := (&ChatResponse[openai.ChatCompletionChunk]{chunk}).Thoughts(); !reflect.DeepEqual(res, []string{"thinking"}) {
+		t.Errorf("expected [thinking]; got %q", res)
+	}
+	if err := json.Unmarshal([]byte(`{"choices":[{"index":0,"delta":{"content":"hi"}}]}`), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if res := (&ChatResponse[openai.ChatCompletionChunk]{chunk}).Thoughts(); len(res) != 0 {
+		t.Errorf("expected no thoughts; got %q", res)
+	}
+}
+
+func TestSystemInstruction(t *testing.T) {
+	c := NewWithClient(openai.NewClient(), "").(*ChatGPT)
+	c.SetSystemInstruction(ai.Text("You are a cat."))
+	req := c.createRequest(false, nil, ai.Text("Hello"))
+	if n := len(req.Messages); n != 2 {
+		t.Fatalf("expected 2 messages; got %d", n)
+	}
+	if req.Messages[0].OfSystem == nil {
+		t.Error("expected system message")
+	}
+	c.SetSystemInstruction(ai.Image("https://example.com/cat.jpg"))
+	if _, err := c.wait(t.Context(), nil); err == nil {
+		t.Error("expected error; got nil")
+	}
+}
+
+func TestSetHistory(t *testing.T) {
+	history := []ai.Content{
+		{Role: "user", Parts: []ai.Part{ai.Text("Hello")}},
+		{Role: "user", Parts: []ai.Part{ai.Text("What is it?"), ai.Image("https://example.com/image.jpg")}},
+		{Role: "assistant", Parts: []ai.Part{
+			ai.Text("Let me check."),
+			ai.FunctionCall{ID: "1", Name: "describe", Arguments: `{}`},
+		}},
+		{Role: "tool", Parts: []ai.Part{ai.FunctionResponse{ID: "1", Response: `{"result":"a cat"}`}}},
+	}
+	session := NewWithClient(openai.NewClient(), "").ChatSession()
+	if err := session.SetHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if res := session.History(); !reflect.DeepEqual(history, res) {
+		t.Errorf("expected %v; got %v", history, res)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := aitest.NewOpenAIServer(
+		aitest.Reply{Results: []string{"Hello"}, TokenCount: ai.TokenCount{Prompt: 10, Result: 4, Total: 14, Cached: 6, Reasoning: 1}},
+		aitest.Reply{Chunks: []aitest.Reply{
+			{Results: []string{"Let me "}},
+			{Results: []string{"check."}},
+			{FunctionCalls: []ai.FunctionCall{{ID: "call_1", Name: "weather", Arguments: `{"city":`}}},
+			{FunctionCalls: []ai.FunctionCall{{Arguments: `"Paris"}`}}},
+			{TokenCount: ai.TokenCount{Prompt: 5, Result: 5, Total: 10, Cached: 3, Reasoning: 2}},
+		}},
+		aitest.Error(&aitest.StatusError{StatusCode: 429, Code: "insufficient_quota", Message: "quota"}),
+	)
+	defer s.Close()
+	c, err := New(
+		ai.WithAPIKey("test"),
+		ai.WithEndpoint(s.URL),
+		ai.WithModel("test"),
+		ai.WithHeader("OpenAI-Organization", "org"),
+		ai.WithRetry(ai.RetryPolicy{MaxRetries: -1}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Chat(t.Context(), ai.Text("Hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"Hello"}) {
+		t.Errorf("expected [Hello]; got %q", res)
+	}
+	if tokens, expect := resp.TokenCount(), (ai.TokenCount{Prompt: 10, Result: 4, Total: 14, Cached: 6, Reasoning: 1}); tokens != expect {
+		t.Errorf("expected %+v; got %+v", expect, tokens)
+	}
+
+	session := c.ChatSession()
+	stream, err := session.ChatStream(t.Context(), ai.Text("Weather in Paris?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var tokens ai.TokenCount
+	for {
+		resp, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if resp.TokenCount().Total > 0 {
+			tokens = resp.TokenCount()
+		}
+	}
+	if expect := (ai.TokenCount{Prompt: 5, Result: 5, Total: 10, Cached: 3, Reasoning: 2}); tokens != expect {
+		t.Errorf("expected %+v; got %+v", expect, tokens)
+	}
+	history := session.History()
+	if n := len(history); n != 2 {
+		t.Fatalf("expected 2 contents; got %d", n)
+	}
+	expect := []ai.Part{ai.Text("Let me check."), ai.FunctionCall{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`}}
+	if !reflect.DeepEqual(history[1].Parts, expect) {
+		t.Errorf("expected %v; got %v", expect, history[1].Parts)
+	}
+
+	_, err = c.Chat(t.Context(), ai.Text("Hi"))
+	if info, ok := ai.ClassifyError(err); !ok || !info.QuotaExceeded {
+		t.Errorf("expected quota error; got %v", err)
+	}
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("expected 3 requests; got %d", n)
+	}
+	var streamReq struct {
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	if err := s.Requests()[1].JSON(&streamReq); err != nil {
+		t.Fatal(err)
+	} else if !streamReq.StreamOptions.IncludeUsage {
+		t.Error("expected stream usage to be requested")
+	}
+	if org := s.Requests()[0].Header.Get("OpenAI-Organization"); org != "org" {
+		t.Errorf("expected organization org; got %q", org)
+	}
+	var req struct{ Model string }
+	if err := s.Requests()[0].JSON(&req); err != nil {
+		t.Fatal(err)
+	} else if req.Model != "test" {
+		t.Errorf("expected model test; got %q", req.Model)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	if _, err := NewCompatible(ai.WithEndpoint("http://localhost")); err == nil {
+		t.Error("expected error for missing model; got nil")
+	}
+	s := aitest.NewOpenAIServer(aitest.Text("{}"))
+	defer s.Close()
+	t.Setenv("OPENAI_API_KEY", "secret")
+	factory, ok := ai.Lookup(ai.OpenAICompatible)
+	if !ok {
+		t.Fatal("OpenAICompatible not registered")
+	}
+	c, err := factory(t.Context(), ai.WithEndpoint(s.URL), ai.WithModel("llama"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llms := c.LLMs(); llms != ai.OpenAICompatible {
+		t.Errorf("expected %s; got %s", ai.OpenAICompatible, llms)
+	}
+	c.SetCount(2)
+	c.SetThinking(true)
+	c.SetJSONResponse(true, &ai.JSONSchema{Name: "test", Schema: ai.Schema{Type: "object"}})
+	if _, err := c.Chat(t.Context(), ai.Text("Hi")); err != nil {
+		t.Fatal(err)
+	}
+	req := s.Requests()[0]
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Errorf("expected no authorization; got %q", auth)
+	}
+	var body map[string]any
+	if err := req.JSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["model"] != "llama" {
+		t.Errorf("expected model llama; got %v", body["model"])
+	}
+	for _, i := range []string{"n", "reasoning_effort"} {
+		if _, ok := body[i]; ok {
+			t.Errorf("expected no %s; got %v", i, body[i])
+		}
+	}
+	if format, _ := body["response_format"].(map[string]any); format["type"] != "json_object" {
+		t.Errorf("expected json_object response format; got %v", body["response_format"])
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	defer func(n int) { EmbeddingBatchSize = n }(EmbeddingBatchSize)
+	EmbeddingBatchSize = 2
+	s := aitest.NewOpenAIServer()
+	defer s.Close()
+	c, err := New(
+		ai.WithAPIKey("test"),
+		ai.WithEndpoint(s.URL),
+		ai.WithEmbeddingConfig(ai.EmbeddingConfig{Dimensions: 4}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{"a", "b", "c"}
+	vectors, tokens, err := c.(ai.Embedder).Embed(t.Context(), texts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range texts {
+		if expect := aitest.Embedding(text, 4); !reflect.DeepEqual(vectors[i], expect) {
+			t.Errorf("#%d: expected %v; got %v", i, expect, vectors[i])
+		}
+	}
+	if tokens.Total != 3 {
+		t.Errorf("expected 3 tokens; got %d", tokens.Total)
+	}
+	if n := len(s.Requests()); n != 2 {
+		t.Fatalf("expected 2 requests; got %d", n)
+	}
+	var req struct {
+		Input      []string
+		Model      string
+		Dimensions int
+	}
+	if err := s.Requests()[1].JSON(&req); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req.Input, []string{"c"}) || req.Model != defaultEmbeddingModel || req.Dimensions != 4 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	for _, tc := range []struct {
+		text   string
+		tokens int64
+	}{
+		{"", 0},
+		{"Hello, world!", 4},
+		{"1234567", 3},
+		{"a  b", 3},
+		{"internationalization", 3},
+		{"你好", 2},
+	} {
+		if n := countText(tc.text); n != tc.tokens {
+			t.Errorf("%q: expected %d tokens; got %d", tc.text, tc.tokens, n)
+		}
+	}
+	c, err := New(ai.WithAPIKey("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.CountTokens(t.Context(), ai.Text("Hello, world!")); err != nil {
+		t.Fatal(err)
+	} else if n != 4+tokensPerMessage {
+		t.Errorf("expected %d tokens; got %d", 4+tokensPerMessage, n)
+	}
+}
@@ -5,31 +5,87 @@ import (
 	"errors"
 
 	"github.com/sunshineplan/ai"
-	"github.com/sunshineplan/ai/chatgpt"
-	"github.com/sunshineplan/ai/gemini"
+	_ "github.com/sunshineplan/ai/anthropic"
+	_ "github.com/sunshineplan/ai/chatgpt"
+	_ "github.com/sunshineplan/ai/gemini"
+	_ "github.com/sunshineplan/ai/ollama"
 )
 
-func New(cfg ai.ClientConfig) (client ai.AI, err error) {
+func New(cfg ai.ClientConfig) (ai.AI, error) {
 	if cfg.LLMs == "" {
 		return nil, errors.New("empty AI")
 	}
+	factory, ok := ai.Lookup(cfg.LLMs)
+	if !ok {
+		return nil, errors.New("unknown LLMs: " + string(cfg.LLMs))
+	}
 	opts := []ai.ClientOption{
 		ai.WithAPIKey(cfg.APIKey),
 		ai.WithEndpoint(cfg.Endpoint),
 		ai.WithProxy(cfg.Proxy),
+		ai.WithHTTPClient(cfg.HTTPClient),
+		ai.WithTransport(cfg.Transport),
+		ai.WithTimeout(cfg.Timeout),
 		ai.WithModel(cfg.Model),
 		ai.WithModelConfig(cfg.ModelConfig),
+		ai.WithEmbeddingConfig(cfg.Embedding),
+	}
+	for k, v := range cfg.Header {
+		for _, v := range v {
+			opts = append(opts, ai.WithHeader(k, v))
+		}
 	}
 	if cfg.Limit != nil {
 		opts = append(opts, ai.WithLimit(*cfg.Limit))
 	}
-	switch cfg.LLMs {
-	case ai.ChatGPT:
-		client, err = chatgpt.New(opts...)
-	case ai.Gemini:
-		client, err = gemini.New(context.Background(), opts...)
-	default:
-		err = errors.New("unknown LLMs: " + string(cfg.LLMs))
+	if cfg.TokenLimit != nil {
+		opts = append(opts, ai.WithTokenLimit(*cfg.TokenLimit))
+	}
+	if cfg.Concurrency != nil {
+		opts = append(opts, ai.WithConcurrency(*cfg.Concurrency))
+	}
+	if cfg.Retry != nil {
+		opts = append(opts, ai.WithRetry(*cfg.Retry))
+	}
+	return factory(context.Background(), opts...)
+}
+
+// NewFallback creates an AI which falls back to the next client in order when a call fails
+// with a retryable error. See ai.Fallback.
+func NewFallback(cfgs ...ai.ClientConfig) (ai.AI, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("no AI")
+	}
+	var backends []ai.Backend
+	for _, cfg := range cfgs {
+		c, err := New(cfg)
+		if err != nil {
+			for _, i := range backends {
+				i.Close()
+			}
+			return nil, err
+		}
+		backends = append(backends, ai.Backend{AI: c})
+	}
+	return ai.Fallback(backends...), nil
+}
+
+// NewPool creates an AI which spreads requests over clients, typically of the same provider
+// using different API keys or endpoints. See ai.Pool.
+func NewPool(balance ai.Balance, cfgs ...ai.ClientConfig) (ai.AI, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("no AI")
+	}
+	var members []ai.AI
+	for _, cfg := range cfgs {
+		c, err := New(cfg)
+		if err != nil {
+			for _, i := range members {
+				i.Close()
+			}
+			return nil, err
+		}
+		members = append(members, c)
 	}
-	return
+	return ai.Pool(balance, members...), nil
 }
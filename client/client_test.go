@@ -0,0 +1,38 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/sunshineplan/ai"
+)
+
+func TestNew(t *testing.T) {
+	for _, llms := range []ai.LLMs{ai.ChatGPT, ai.Gemini, ai.Anthropic} {
+		c, err := New(ai.ClientConfig{LLMs: llms, APIKey: "test"})
+		if err != nil {
+			t.Errorf("%s: %s", llms, err)
+			continue
+		}
+		if c.LLMs() != llms {
+			t.Errorf("expected %s; got %s", llms, c.LLMs())
+		}
+		c.Close()
+	}
+	if _, err := New(ai.ClientConfig{LLMs: "unknown"}); err == nil {
+		t.Error("expected error; got nil")
+	}
+}
+
+func TestNewEmbedder(t *testing.T) {
+	for _, llms := range []ai.LLMs{ai.ChatGPT, ai.Gemini} {
+		c, err := New(ai.ClientConfig{LLMs: llms, APIKey: "test", Retry: &ai.RetryPolicy{}})
+		if err != nil {
+			t.Errorf("%s: %s", llms, err)
+			continue
+		}
+		if _, ok := c.(ai.Embedder); !ok {
+			t.Errorf("%s: expected Embedder with retry policy", llms)
+		}
+		c.Close()
+	}
+}
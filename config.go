@@ -1,30 +1,52 @@
 package ai
 
+import (
+	"net/http"
+	"time"
+)
+
 type ClientConfig struct {
 	LLMs LLMs
 
 	APIKey   string
 	Endpoint string
 	Proxy    string
-
-	Limit *int64
+	// HTTPClient is the HTTP client used for requests. It is not modified.
+	HTTPClient *http.Client
+	// Transport is the HTTP transport of the client. Proxy applies to it if it is an *http.Transport.
+	Transport http.RoundTripper
+	// Header holds extra headers sent with every request, replacing those set by the provider.
+	Header http.Header
+	// Timeout limits the time of each HTTP request, including reading the response body of streams.
+	Timeout time.Duration
+
+	Limit       *int64
+	TokenLimit  *int64
+	Concurrency *int64
+	Retry       *RetryPolicy
 
 	Model       string
 	ModelConfig ModelConfig
+	Embedding   EmbeddingConfig
 }
 
 type ModelConfig struct {
-	Count        *int64
-	MaxTokens    *int64
-	Temperature  *float64
-	TopP         *float64
-	JSONResponse *bool
-	JSONSchema   *JSONSchema
-	Tools        []Function
-	ToolConfig   FunctionCallingMode
+	SystemInstruction string
+	Count             *int64
+	MaxTokens         *int64
+	Temperature       *float64
+	TopP              *float64
+	JSONResponse      *bool
+	JSONSchema        *JSONSchema
+	Tools             []Function
+	ToolConfig        FunctionCallingMode
+	Thinking          *ThinkingConfig
 }
 
 func ApplyModelConfig(ai AI, cfg ModelConfig) {
+	if cfg.SystemInstruction != "" {
+		ai.SetSystemInstruction(Text(cfg.SystemInstruction))
+	}
 	if cfg.Count != nil {
 		ai.SetCount(*cfg.Count)
 	}
@@ -40,9 +62,24 @@ func ApplyModelConfig(ai AI, cfg ModelConfig) {
 	if cfg.JSONResponse != nil {
 		ai.SetJSONResponse(*cfg.JSONResponse, cfg.JSONSchema)
 	}
+	if cfg.Thinking != nil {
+		ai.SetThinkingConfig(*cfg.Thinking)
+	}
 	ai.SetFunctionCall(cfg.Tools, cfg.ToolConfig)
 }
 
+func ApplyLimits(l Limiter, cfg ClientConfig) {
+	if cfg.Limit != nil {
+		l.SetLimit(*cfg.Limit)
+	}
+	if cfg.TokenLimit != nil {
+		l.SetTokenLimit(*cfg.TokenLimit)
+	}
+	if cfg.Concurrency != nil {
+		l.SetConcurrency(*cfg.Concurrency)
+	}
+}
+
 type ClientOption interface {
 	Apply(*ClientConfig)
 }
@@ -50,9 +87,19 @@ type ClientOption interface {
 func WithAPIKey(apiKey string) ClientOption           { return withAPIKey(apiKey) }
 func WithEndpoint(endpoint string) ClientOption       { return withEndpoint(endpoint) }
 func WithProxy(proxy string) ClientOption             { return withProxy(proxy) }
+func WithHTTPClient(c *http.Client) ClientOption      { return withHTTPClient{c} }
+func WithTransport(t http.RoundTripper) ClientOption  { return withTransport{t} }
+func WithHeader(key, value string) ClientOption       { return withHeader{key, value} }
+func WithTimeout(d time.Duration) ClientOption        { return withTimeout(d) }
 func WithLimit(rpm int64) ClientOption                { return withLimit(rpm) }
+func WithTokenLimit(tpm int64) ClientOption           { return withTokenLimit(tpm) }
+func WithConcurrency(n int64) ClientOption            { return withConcurrency(n) }
+func WithRetry(policy RetryPolicy) ClientOption       { return withRetry(policy) }
 func WithModel(model string) ClientOption             { return withModel(model) }
 func WithModelConfig(config ModelConfig) ClientOption { return withModelConfig(config) }
+func WithEmbeddingConfig(config EmbeddingConfig) ClientOption {
+	return withEmbeddingConfig(config)
+}
 
 type withAPIKey string
 
@@ -66,10 +113,43 @@ type withProxy string
 
 func (w withProxy) Apply(cfg *ClientConfig) { cfg.Proxy = string(w) }
 
+type withHTTPClient struct{ *http.Client }
+
+func (w withHTTPClient) Apply(cfg *ClientConfig) { cfg.HTTPClient = w.Client }
+
+type withTransport struct{ http.RoundTripper }
+
+func (w withTransport) Apply(cfg *ClientConfig) { cfg.Transport = w.RoundTripper }
+
+type withHeader struct{ key, value string }
+
+func (w withHeader) Apply(cfg *ClientConfig) {
+	if cfg.Header == nil {
+		cfg.Header = make(http.Header)
+	}
+	cfg.Header.Add(w.key, w.value)
+}
+
+type withTimeout time.Duration
+
+func (w withTimeout) Apply(cfg *ClientConfig) { cfg.Timeout = time.Duration(w) }
+
 type withLimit int64
 
 func (w withLimit) Apply(cfg *ClientConfig) { cfg.Limit = (*int64)(&w) }
 
+type withTokenLimit int64
+
+func (w withTokenLimit) Apply(cfg *ClientConfig) { cfg.TokenLimit = (*int64)(&w) }
+
+type withConcurrency int64
+
+func (w withConcurrency) Apply(cfg *ClientConfig) { cfg.Concurrency = (*int64)(&w) }
+
+type withRetry RetryPolicy
+
+func (w withRetry) Apply(cfg *ClientConfig) { cfg.Retry = (*RetryPolicy)(&w) }
+
 type withModel string
 
 func (w withModel) Apply(cfg *ClientConfig) { cfg.Model = string(w) }
@@ -77,3 +157,7 @@ func (w withModel) Apply(cfg *ClientConfig) { cfg.Model = string(w) }
 type withModelConfig ModelConfig
 
 func (w withModelConfig) Apply(cfg *ClientConfig) { cfg.ModelConfig = ModelConfig(w) }
+
+type withEmbeddingConfig EmbeddingConfig
+
+func (w withEmbeddingConfig) Apply(cfg *ClientConfig) { cfg.Embedding = EmbeddingConfig(w) }
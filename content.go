@@ -2,6 +2,8 @@ package ai
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -13,6 +15,81 @@ type Content struct {
 	Role  string
 }
 
+var (
+	_ json.Marshaler   = Content{}
+	_ json.Unmarshaler = new(Content)
+)
+
+type jsonContent struct {
+	Role  string     `json:"role"`
+	Parts []jsonPart `json:"parts"`
+}
+
+type jsonPart struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	URL       string `json:"url,omitempty"`
+	MIMEType  string `json:"mime_type,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Response  string `json:"response,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Redacted  bool   `json:"redacted,omitempty"`
+	Provider  LLMs   `json:"provider,omitempty"`
+}
+
+func (c Content) MarshalJSON() ([]byte, error) {
+	v := jsonContent{Role: c.Role, Parts: []jsonPart{}}
+	for _, i := range c.Parts {
+		switch p := i.(type) {
+		case Text:
+			v.Parts = append(v.Parts, jsonPart{Type: "text", Text: string(p)})
+		case Image:
+			v.Parts = append(v.Parts, jsonPart{Type: "image", URL: string(p)})
+		case Blob:
+			v.Parts = append(v.Parts, jsonPart{Type: "blob", MIMEType: p.MIMEType, Data: p.Data})
+		case FunctionCall:
+			v.Parts = append(v.Parts, jsonPart{Type: "function_call", ID: p.ID, Name: p.Name, Arguments: p.Arguments})
+		case FunctionResponse:
+			v.Parts = append(v.Parts, jsonPart{Type: "function_response", ID: p.ID, Name: p.Name, Response: p.Response})
+		case Thought:
+			v.Parts = append(v.Parts, jsonPart{Type: "thought", Text: p.Text, Signature: p.Signature, Redacted: p.Redacted, Provider: p.Provider})
+		default:
+			return nil, fmt.Errorf("ai: unsupported part type %T", i)
+		}
+	}
+	return json.Marshal(v)
+}
+
+func (c *Content) UnmarshalJSON(b []byte) error {
+	var v jsonContent
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	c.Role, c.Parts = v.Role, nil
+	for _, i := range v.Parts {
+		switch i.Type {
+		case "text":
+			c.Parts = append(c.Parts, Text(i.Text))
+		case "image":
+			c.Parts = append(c.Parts, Image(i.URL))
+		case "blob":
+			c.Parts = append(c.Parts, Blob{MIMEType: i.MIMEType, Data: i.Data})
+		case "function_call":
+			c.Parts = append(c.Parts, FunctionCall{ID: i.ID, Name: i.Name, Arguments: i.Arguments})
+		case "function_response":
+			c.Parts = append(c.Parts, FunctionResponse{ID: i.ID, Name: i.Name, Response: i.Response})
+		case "thought":
+			c.Parts = append(c.Parts, Thought{Text: i.Text, Signature: i.Signature, Redacted: i.Redacted, Provider: i.Provider})
+		default:
+			return fmt.Errorf("ai: unsupported part type %q", i.Type)
+		}
+	}
+	return nil
+}
+
 type Part interface {
 	implementsPart()
 }
@@ -96,7 +173,24 @@ func (FunctionCall) implementsPart() {}
 
 type FunctionResponse struct {
 	ID       string
+	Name     string
 	Response string
 }
 
 func (FunctionResponse) implementsPart() {}
+
+// Thought is a thought of the model kept in session history, so that it can be sent back
+// to the provider which generated it, as required when replying to tool use with thinking on.
+// Providers leave out thoughts generated by other providers and those they do not accept back.
+type Thought struct {
+	Text string
+	// Signature is opaque data of the provider which must be sent back unchanged,
+	// such as the signature of an Anthropic thinking block or a Gemini thought signature.
+	Signature []byte
+	// Redacted reports whether the thought is encrypted in Signature instead of given in Text.
+	Redacted bool
+	// Provider is the provider which generated the thought and signed it.
+	Provider LLMs
+}
+
+func (Thought) implementsPart() {}
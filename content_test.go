@@ -0,0 +1,38 @@
+package ai
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestContentJSON(t *testing.T) {
+	history := []Content{
+		{Role: "user", Parts: []Part{
+			Text("What is in this picture?"),
+			Image("https://example.com/image.jpg"),
+			Blob{MIMEType: "image/png", Data: []byte{1, 2, 3}},
+		}},
+		{Role: "assistant", Parts: []Part{
+			Thought{Text: "Describe the image.", Signature: []byte("signature"), Provider: Gemini},
+			Thought{Signature: []byte("data"), Redacted: true, Provider: Anthropic},
+			Text("Let me check."),
+			FunctionCall{ID: "1", Name: "describe", Arguments: `{"detail":true}`},
+		}},
+		{Role: "tool", Parts: []Part{FunctionResponse{ID: "1", Response: `{"result":"a cat"}`}}},
+	}
+	b, err := json.Marshal(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []Content
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history, res) {
+		t.Errorf("expected %v; got %v", history, res)
+	}
+	if err := json.Unmarshal([]byte(`{"role":"user","parts":[{"type":"unknown"}]}`), new(Content)); err == nil {
+		t.Error("expected error; got nil")
+	}
+}
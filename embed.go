@@ -0,0 +1,96 @@
+package ai
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"strings"
+)
+
+// Embedder creates embedding vectors for texts. The chatgpt and gemini clients implement it,
+// and it is reached by type assertion on the client. Wrappers such as Retry, Wrap, Fallback,
+// Pool and Cached implement it when the AI they wrap does.
+type Embedder interface {
+	SetEmbeddingConfig(EmbeddingConfig)
+	// Embed returns one vector per text, in order. Large inputs are sent in batches,
+	// each going through the limits of the client.
+	Embed(ctx context.Context, texts ...string) ([][]float32, TokenCount, error)
+}
+
+// embedderAI is a wrapper of an AI which keeps the Embedder of the AI it wraps.
+type embedderAI struct {
+	AI
+	Embedder
+}
+
+// withEmbedder returns wrapper with the Embedder of ai if ai implements it, or wrapper otherwise.
+func withEmbedder(wrapper, ai AI) AI {
+	if e, ok := ai.(Embedder); ok {
+		return &embedderAI{wrapper, e}
+	}
+	return wrapper
+}
+
+// EmbeddingConfig configures an Embedder. Zero values use the defaults of the provider.
+type EmbeddingConfig struct {
+	Model      string
+	Dimensions int64
+	Task       EmbeddingTask
+}
+
+var _ encoding.TextUnmarshaler = new(EmbeddingTask)
+
+// EmbeddingTask is what embeddings are used for, which some models optimize for.
+type EmbeddingTask int
+
+func (t *EmbeddingTask) UnmarshalText(text []byte) error {
+	switch strings.ReplaceAll(strings.ToLower(string(text)), "-", "_") {
+	case "retrieval_query":
+		*t = EmbeddingRetrievalQuery
+	case "retrieval_document":
+		*t = EmbeddingRetrievalDocument
+	case "semantic_similarity":
+		*t = EmbeddingSemanticSimilarity
+	case "classification":
+		*t = EmbeddingClassification
+	case "clustering":
+		*t = EmbeddingClustering
+	default:
+		*t = 0
+	}
+	return nil
+}
+
+const (
+	EmbeddingRetrievalQuery EmbeddingTask = iota + 1
+	EmbeddingRetrievalDocument
+	EmbeddingSemanticSimilarity
+	EmbeddingClassification
+	EmbeddingClustering
+)
+
+// EmbedBatches embeds texts in batches of at most size texts, calling embed for each batch in order,
+// and returns all vectors with the summed token usage.
+func EmbedBatches(
+	ctx context.Context,
+	texts []string,
+	size int,
+	embed func(context.Context, []string) ([][]float32, TokenCount, error),
+) (vectors [][]float32, usage TokenCount, err error) {
+	if size <= 0 {
+		size = len(texts)
+	}
+	for start := 0; start < len(texts); start += size {
+		batch := texts[start:min(start+size, len(texts))]
+		res, tokens, err := embed(ctx, batch)
+		if err != nil {
+			return nil, usage, err
+		}
+		if len(res) != len(batch) {
+			return nil, usage, fmt.Errorf("got %d embeddings for %d texts", len(res), len(batch))
+		}
+		vectors = append(vectors, res...)
+		usage = usage.Add(tokens)
+	}
+	return
+}
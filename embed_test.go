@@ -0,0 +1,97 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testEmbedder struct {
+	AI
+	errs  []error
+	calls int
+}
+
+func (e *testEmbedder) SetEmbeddingConfig(EmbeddingConfig) {}
+
+func (e *testEmbedder) Embed(_ context.Context, texts ...string) ([][]float32, TokenCount, error) {
+	e.calls++
+	if len(e.errs) > 0 {
+		err := e.errs[0]
+		e.errs = e.errs[1:]
+		return nil, TokenCount{}, err
+	}
+	return make([][]float32, len(texts)), TokenCount{}, nil
+}
+
+func TestEmbedderWrappers(t *testing.T) {
+	e := &testEmbedder{errs: []error{io.ErrUnexpectedEOF}}
+	retry, ok := Retry(e, RetryPolicy{BaseDelay: time.Millisecond}).(Embedder)
+	if !ok {
+		t.Fatal("expected Retry to keep Embedder")
+	}
+	if _, _, err := retry.Embed(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if e.calls != 2 {
+		t.Errorf("expected 2 calls; got %d", e.calls)
+	}
+	for name, c := range map[string]AI{
+		"Wrap":     Wrap(e, func(next ChatFunc) ChatFunc { return next }),
+		"Fallback": Fallback(Backend{AI: e}, Backend{AI: &fallbackBackend{}}),
+		"Pool":     Pool(RoundRobin, e, &testEmbedder{}),
+		"Cached":   Cached(e, NewMemoryCache(1), 0),
+	} {
+		if _, ok := c.(Embedder); !ok {
+			t.Errorf("expected %s to keep Embedder", name)
+		}
+	}
+	if _, ok := Pool(RoundRobin, e, &fallbackBackend{}).(Embedder); ok {
+		t.Error("expected no Embedder for pool with members which are not Embedder")
+	}
+	if _, ok := Retry(&fallbackBackend{}, RetryPolicy{}).(Embedder); ok {
+		t.Error("expected no Embedder for Retry of AI which is not Embedder")
+	}
+}
+
+func TestEmbedBatches(t *testing.T) {
+	var batches [][]string
+	embed := func(_ context.Context, texts []string) ([][]float32, TokenCount, error) {
+		batches = append(batches, texts)
+		var res [][]float32
+		for _, i := range texts {
+			res = append(res, []float32{float32(len(i))})
+		}
+		return res, TokenCount{Prompt: int64(len(texts)), Total: int64(len(texts))}, nil
+	}
+	vectors, tokens, err := EmbedBatches(context.Background(), []string{"a", "bb", "ccc", "dddd", "eeeee"}, 2, embed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := [][]float32{{1}, {2}, {3}, {4}, {5}}; !reflect.DeepEqual(vectors, expect) {
+		t.Errorf("expected %v; got %v", expect, vectors)
+	}
+	if len(batches) != 3 || tokens.Total != 5 {
+		t.Errorf("expected 3 batches and 5 tokens; got %d and %d", len(batches), tokens.Total)
+	}
+
+	if _, _, err := EmbedBatches(context.Background(), []string{"a"}, 0, func(context.Context, []string) ([][]float32, TokenCount, error) {
+		return nil, TokenCount{}, nil
+	}); err == nil {
+		t.Error("expected error for missing embeddings; got nil")
+	}
+	errTest := errors.New("test")
+	if _, _, err := EmbedBatches(context.Background(), []string{"a"}, 0, func(context.Context, []string) ([][]float32, TokenCount, error) {
+		return nil, TokenCount{}, errTest
+	}); err != errTest {
+		t.Errorf("expected errTest; got %v", err)
+	}
+
+	var task EmbeddingTask
+	if err := task.UnmarshalText([]byte("Retrieval-Document")); err != nil || task != EmbeddingRetrievalDocument {
+		t.Errorf("expected EmbeddingRetrievalDocument; got %v %v", task, err)
+	}
+}
@@ -0,0 +1,320 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var errBudgetExceeded = errors.New("time budget exceeded")
+
+// Backend is a member of a fallback AI.
+type Backend struct {
+	AI
+	// Timeout is the time budget of a call on the backend, after which the call moves on
+	// to the next backend. For streams it covers the time until the first chunk. Zero means no budget.
+	Timeout time.Duration
+}
+
+// ServedResponse is a response which records the backend that served it.
+type ServedResponse struct {
+	ChatResponse
+	Backend AI
+}
+
+// ServedBy returns the backend that served the response, or nil if it is unknown.
+func ServedBy(resp ChatResponse) AI {
+	if v, ok := resp.(*ServedResponse); ok {
+		return v.Backend
+	}
+	return nil
+}
+
+// Fallback returns an AI which sends each call to the backends in order, moving on to the next
+// backend when a call fails with a retryable error or exceeds the time budget of the backend.
+// Responses are *ServedResponse values.
+//
+// Every call, in sessions too, starts from the first backend, so that calls go back to it once it recovers.
+// A session moving to another backend takes its history along.
+//
+// Model settings are applied to all backends. As model names are provider specific,
+// LLMs, Model, SetModel, ListModels and CountTokens refer to the active backend,
+// which is the one that served the last call, or the first backend before any call.
+//
+// Embeddings are created by the first backend only, as vectors of different models cannot be compared.
+func Fallback(backends ...Backend) AI {
+	if len(backends) == 0 {
+		panic("ai: Fallback requires at least one backend")
+	}
+	return withEmbedder(&fallbackAI{backends: backends}, backends[0].AI)
+}
+
+type fallbackAI struct {
+	backends []Backend
+	active   atomic.Int64
+}
+
+func (ai *fallbackAI) backend() Backend { return ai.backends[ai.active.Load()] }
+
+// attempt is a call on a backend within its time budget.
+type attempt struct {
+	context.Context
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+}
+
+func (b Backend) attempt(ctx context.Context) *attempt {
+	a := new(attempt)
+	a.Context, a.cancel = context.WithCancelCause(ctx)
+	if b.Timeout > 0 {
+		a.timer = time.AfterFunc(b.Timeout, func() { a.cancel(errBudgetExceeded) })
+	}
+	return a
+}
+
+// stop stops the time budget, reporting whether it was not exceeded.
+func (a *attempt) stop() bool {
+	return a.timer == nil || a.timer.Stop()
+}
+
+func (a *attempt) close() {
+	a.stop()
+	a.cancel(nil)
+}
+
+// do calls fn on the backends in order until a call succeeds, making its backend the active one,
+// or fails with an error which should not be passed on to the next backend.
+// On success, fn takes over the attempt and must close it.
+func (ai *fallbackAI) do(ctx context.Context, fn func(a *attempt, i int) error) error {
+	var errs []error
+	for i, b := range ai.backends {
+		a := b.attempt(ctx)
+		err := fn(a, i)
+		if err == nil {
+			ai.active.Store(int64(i))
+			return nil
+		}
+		a.close()
+		expired := context.Cause(a) == errBudgetExceeded
+		if expired {
+			err = errBudgetExceeded
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b.LLMs(), err))
+		if retry, _ := IsRetryable(err); ctx.Err() != nil || !expired && !retry {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ai *fallbackAI) LLMs() LLMs            { return ai.backend().LLMs() }
+func (ai *fallbackAI) Model() string         { return ai.backend().Model() }
+func (ai *fallbackAI) SetModel(model string) { ai.backend().SetModel(model) }
+func (ai *fallbackAI) ListModels(ctx context.Context) ([]string, error) {
+	return ai.backend().ListModels(ctx)
+}
+
+func (ai *fallbackAI) CountTokens(ctx context.Context, parts ...Part) (int64, error) {
+	return ai.backend().CountTokens(ctx, parts...)
+}
+
+func (ai *fallbackAI) SetLimit(rpm int64) {
+	for _, i := range ai.backends {
+		i.SetLimit(rpm)
+	}
+}
+func (ai *fallbackAI) Limit() (rpm int64) { return ai.backends[0].Limit() }
+
+func (ai *fallbackAI) SetTokenLimit(tpm int64) {
+	for _, i := range ai.backends {
+		i.SetTokenLimit(tpm)
+	}
+}
+func (ai *fallbackAI) TokenLimit() (tpm int64) { return ai.backends[0].TokenLimit() }
+
+func (ai *fallbackAI) SetConcurrency(n int64) {
+	for _, i := range ai.backends {
+		i.SetConcurrency(n)
+	}
+}
+func (ai *fallbackAI) Concurrency() (n int64) { return ai.backends[0].Concurrency() }
+
+func (ai *fallbackAI) each(fn func(AI)) {
+	for _, i := range ai.backends {
+		fn(i.AI)
+	}
+}
+
+func (ai *fallbackAI) SetSystemInstruction(parts ...Part) {
+	ai.each(func(c AI) { c.SetSystemInstruction(parts...) })
+}
+func (ai *fallbackAI) SetFunctionCall(f []Function, mode FunctionCallingMode) {
+	ai.each(func(c AI) { c.SetFunctionCall(f, mode) })
+}
+func (ai *fallbackAI) SetCount(x int64)         { ai.each(func(c AI) { c.SetCount(x) }) }
+func (ai *fallbackAI) SetMaxTokens(x int64)     { ai.each(func(c AI) { c.SetMaxTokens(x) }) }
+func (ai *fallbackAI) SetTemperature(x float64) { ai.each(func(c AI) { c.SetTemperature(x) }) }
+func (ai *fallbackAI) SetTopP(x float64)        { ai.each(func(c AI) { c.SetTopP(x) }) }
+func (ai *fallbackAI) SetJSONResponse(set bool, schema *JSONSchema) {
+	ai.each(func(c AI) { c.SetJSONResponse(set, schema) })
+}
+func (ai *fallbackAI) SetThinking(set bool) { ai.each(func(c AI) { c.SetThinking(set) }) }
+func (ai *fallbackAI) SetThinkingConfig(cfg ThinkingConfig) {
+	ai.each(func(c AI) { c.SetThinkingConfig(cfg) })
+}
+
+func (ai *fallbackAI) Chat(ctx context.Context, parts ...Part) (resp ChatResponse, err error) {
+	err = ai.do(ctx, func(a *attempt, i int) (err error) {
+		defer a.close()
+		backend := ai.backends[i].AI
+		if resp, err = backend.Chat(a, parts...); err == nil {
+			resp = &ServedResponse{resp, backend}
+		}
+		return
+	})
+	return
+}
+
+func (ai *fallbackAI) ChatStream(ctx context.Context, parts ...Part) (stream ChatStream, err error) {
+	err = ai.do(ctx, func(a *attempt, i int) (err error) {
+		backend := ai.backends[i].AI
+		stream, err = openStream(a, backend, func() (ChatStream, error) { return backend.ChatStream(a, parts...) })
+		return
+	})
+	return
+}
+
+// openStream opens a stream and waits for its first chunk within the time budget.
+func openStream(a *attempt, backend AI, open func() (ChatStream, error)) (ChatStream, error) {
+	stream, err := open()
+	if err != nil {
+		return nil, err
+	}
+	first, err := stream.Next()
+	if !a.stop() && err == nil {
+		err = errBudgetExceeded
+	}
+	if err != nil && err != io.EOF {
+		stream.Close()
+		return nil, err
+	}
+	return &fallbackStream{stream, a, backend, first, err}, nil
+}
+
+type fallbackStream struct {
+	ChatStream
+	attempt  *attempt
+	backend  AI
+	first    ChatResponse
+	firstErr error
+}
+
+func (s *fallbackStream) Next() (resp ChatResponse, err error) {
+	if s.first != nil || s.firstErr != nil {
+		resp, err = s.first, s.firstErr
+		s.first, s.firstErr = nil, nil
+	} else {
+		resp, err = s.ChatStream.Next()
+	}
+	if resp != nil {
+		resp = &ServedResponse{resp, s.backend}
+	}
+	return
+}
+
+func (s *fallbackStream) Close() error {
+	defer s.attempt.close()
+	return s.ChatStream.Close()
+}
+
+func (ai *fallbackAI) ChatSession() ChatSession {
+	return &fallbackSession{ai: ai, session: ai.backends[0].ChatSession()}
+}
+
+func (ai *fallbackAI) Close() error {
+	var errs []error
+	for _, i := range ai.backends {
+		errs = append(errs, i.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// fallbackSession keeps its conversation on the backend which served the last call,
+// migrating the history when a call is served by another backend.
+type fallbackSession struct {
+	ai      *fallbackAI
+	current int
+	session ChatSession
+}
+
+func (s *fallbackSession) open(i int, history []Content) (ChatSession, error) {
+	if i == s.current {
+		return s.session, nil
+	}
+	session := s.ai.backends[i].ChatSession()
+	if err := session.SetHistory(history); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *fallbackSession) use(i int, session ChatSession) {
+	s.current, s.session = i, session
+}
+
+func (s *fallbackSession) Chat(ctx context.Context, parts ...Part) (resp ChatResponse, err error) {
+	history := s.session.History()
+	err = s.ai.do(ctx, func(a *attempt, i int) error {
+		defer a.close()
+		session, err := s.open(i, history)
+		if err != nil {
+			return err
+		}
+		restore := restoreHistory(session)
+		if resp, err = session.Chat(a, parts...); err != nil {
+			restore()
+			return err
+		}
+		s.use(i, session)
+		resp = &ServedResponse{resp, s.ai.backends[i].AI}
+		return nil
+	})
+	return
+}
+
+func (s *fallbackSession) ChatStream(ctx context.Context, parts ...Part) (stream ChatStream, err error) {
+	history := s.session.History()
+	err = s.ai.do(ctx, func(a *attempt, i int) error {
+		session, err := s.open(i, history)
+		if err != nil {
+			return err
+		}
+		// Sessions add the messages to their history once the stream is opened,
+		// which is rolled back if it fails before the first chunk.
+		restore := restoreHistory(session)
+		if stream, err = openStream(a, s.ai.backends[i].AI, func() (ChatStream, error) {
+			return session.ChatStream(a, parts...)
+		}); err != nil {
+			restore()
+			return err
+		}
+		s.use(i, session)
+		return nil
+	})
+	return
+}
+
+func (s *fallbackSession) History() []Content { return s.session.History() }
+
+func (s *fallbackSession) SetHistory(history []Content) error { return s.session.SetHistory(history) }
+
+func (s *fallbackSession) SnapshotHistory() func() {
+	current, session, restore := s.current, s.session, restoreHistory(s.session)
+	return func() {
+		s.current, s.session = current, session
+		restore()
+	}
+}
@@ -0,0 +1,158 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fallbackBackend struct {
+	AI
+	name    LLMs
+	err     error
+	delay   time.Duration
+	calls   int
+	history []Content
+}
+
+func (b *fallbackBackend) LLMs() LLMs { return b.name }
+
+func (b *fallbackBackend) Chat(ctx context.Context, parts ...Part) (ChatResponse, error) {
+	b.calls++
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(b.delay):
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	return testResponse{results: []string{string(b.name)}}, nil
+}
+
+func (b *fallbackBackend) ChatSession() ChatSession { return &fallbackBackendSession{b} }
+
+type fallbackBackendSession struct{ *fallbackBackend }
+
+func (s *fallbackBackendSession) Chat(ctx context.Context, parts ...Part) (ChatResponse, error) {
+	resp, err := s.fallbackBackend.Chat(ctx, parts...)
+	if err == nil {
+		s.history = append(s.history, Content{Role: RoleUser, Parts: parts}, Content{Role: RoleAssistant, Parts: []Part{Text(s.name)}})
+	}
+	return resp, err
+}
+
+// ChatStream adds the messages to the history once the stream is opened, as provider sessions do.
+func (s *fallbackBackendSession) ChatStream(_ context.Context, parts ...Part) (ChatStream, error) {
+	s.calls++
+	s.history = append(s.history, Content{Role: RoleUser, Parts: parts})
+	return fallbackBackendStream{s.err}, nil
+}
+func (s *fallbackBackendSession) History() []Content { return s.history }
+func (s *fallbackBackendSession) SetHistory(history []Content) error {
+	s.history = history
+	return nil
+}
+
+type fallbackBackendStream struct{ err error }
+
+func (s fallbackBackendStream) Next() (ChatResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return nil, io.EOF
+}
+func (fallbackBackendStream) Close() error { return nil }
+
+func TestFallback(t *testing.T) {
+	a := &fallbackBackend{name: "a", err: io.ErrUnexpectedEOF}
+	b := &fallbackBackend{name: "b"}
+	c := Fallback(Backend{AI: a}, Backend{AI: b})
+	resp, err := c.Chat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend := ServedBy(resp); backend != b {
+		t.Errorf("expected backend b; got %v", backend)
+	}
+
+	a, b = &fallbackBackend{name: "a", err: io.ErrClosedPipe}, &fallbackBackend{name: "b"}
+	if _, err := Fallback(Backend{AI: a}, Backend{AI: b}).Chat(context.Background()); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expected ErrClosedPipe; got %v", err)
+	}
+	if b.calls != 0 {
+		t.Errorf("expected no fallback; got %d calls", b.calls)
+	}
+
+	a, b = &fallbackBackend{name: "a", delay: time.Second}, &fallbackBackend{name: "b"}
+	resp, err = Fallback(Backend{AI: a, Timeout: 10 * time.Millisecond}, Backend{AI: b}).Chat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"b"}) {
+		t.Errorf("expected [b]; got %q", res)
+	}
+}
+
+func TestFallbackSession(t *testing.T) {
+	a, b := &fallbackBackend{name: "a"}, &fallbackBackend{name: "b"}
+	c := Fallback(Backend{AI: a}, Backend{AI: b})
+	session := c.ChatSession()
+	if _, err := session.Chat(context.Background(), Text("1")); err != nil {
+		t.Fatal(err)
+	}
+	a.err = io.ErrUnexpectedEOF
+	if _, err := session.Chat(context.Background(), Text("2")); err != nil {
+		t.Fatal(err)
+	}
+	expect := []Content{
+		{Role: RoleUser, Parts: []Part{Text("1")}},
+		{Role: RoleAssistant, Parts: []Part{Text("a")}},
+		{Role: RoleUser, Parts: []Part{Text("2")}},
+		{Role: RoleAssistant, Parts: []Part{Text("b")}},
+	}
+	if history := session.History(); !reflect.DeepEqual(history, expect) {
+		t.Errorf("expected %v; got %v", expect, history)
+	}
+	if llms := c.LLMs(); llms != "b" {
+		t.Errorf("expected active backend b; got %s", llms)
+	}
+
+	a.err = nil
+	resp, err := session.Chat(context.Background(), Text("3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend := ServedBy(resp); backend != a {
+		t.Errorf("expected session back on a; got %v", backend)
+	}
+	expect = append(expect, Content{Role: RoleUser, Parts: []Part{Text("3")}}, Content{Role: RoleAssistant, Parts: []Part{Text("a")}})
+	if history := session.History(); !reflect.DeepEqual(history, expect) {
+		t.Errorf("expected %v; got %v", expect, history)
+	}
+	if llms := c.LLMs(); llms != "a" {
+		t.Errorf("expected active backend a; got %s", llms)
+	}
+}
+
+func TestFallbackSessionStream(t *testing.T) {
+	a, b := &fallbackBackend{name: "a"}, &fallbackBackend{name: "b"}
+	session := Fallback(Backend{AI: a}, Backend{AI: b}).ChatSession()
+	if _, err := session.Chat(context.Background(), Text("1")); err != nil {
+		t.Fatal(err)
+	}
+	expect := session.History()
+	a.err, b.err = io.ErrUnexpectedEOF, io.ErrUnexpectedEOF
+	if _, err := session.ChatStream(context.Background(), Text("2")); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected ErrUnexpectedEOF; got %v", err)
+	}
+	if a.calls != 2 || b.calls != 1 {
+		t.Errorf("expected stream on both backends; got %d and %d calls", a.calls, b.calls)
+	}
+	if history := session.History(); !reflect.DeepEqual(history, expect) {
+		t.Errorf("expected %v; got %v", expect, history)
+	}
+}
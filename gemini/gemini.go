@@ -3,30 +3,77 @@ package gemini
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"iter"
 	"math"
-	"net/http"
-	"net/url"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/sunshineplan/ai"
 
-	"golang.org/x/time/rate"
 	"google.golang.org/genai"
 )
 
-const defaultModel = "gemini-flash-latest"
+const (
+	defaultModel          = "gemini-flash-latest"
+	defaultEmbeddingModel = "gemini-embedding-001"
+)
+
+// EmbeddingBatchSize is the maximum number of texts sent in one embeddings request.
+var EmbeddingBatchSize = 100
+
+var (
+	_ ai.AI       = new(Gemini)
+	_ ai.Embedder = new(Gemini)
+)
 
-var _ ai.AI = new(Gemini)
+func init() {
+	ai.Register(ai.Gemini, New)
+	ai.RegisterErrorClassifier(func(err error) (ai.ErrorInfo, bool) {
+		var e genai.APIError
+		if !errors.As(err, &e) {
+			return ai.ErrorInfo{}, false
+		}
+		info := ai.ErrorInfo{StatusCode: e.Code}
+		for _, i := range e.Details {
+			switch t, _ := i["@type"].(string); {
+			case strings.HasSuffix(t, "google.rpc.RetryInfo"):
+				if s, _ := i["retryDelay"].(string); s != "" {
+					info.RetryAfter, _ = time.ParseDuration(s)
+				}
+			case strings.HasSuffix(t, "google.rpc.QuotaFailure"):
+				violations, _ := i["violations"].([]any)
+				for _, v := range violations {
+					if v, _ := v.(map[string]any); v != nil {
+						if id, _ := v["quotaId"].(string); strings.Contains(id, "PerDay") {
+							info.QuotaExceeded = true
+						}
+					}
+				}
+			}
+		}
+		return info, true
+	})
+}
 
 type Gemini struct {
 	*genai.Client
 	model  string
 	config *genai.GenerateContentConfig
+	// thinking is the config set by SetThinkingConfig, from which the thinking config
+	// of the model is derived, as it depends on the model.
+	thinking *ai.ThinkingConfig
+
+	embedding ai.EmbeddingConfig
 
-	limiter *rate.Limiter
+	systemErr error
+	toolsErr  error
+	schemaErr error
+
+	ai.Limits
 }
 
 func New(ctx context.Context, opts ...ai.ClientOption) (ai.AI, error) {
@@ -34,18 +81,11 @@ func New(ctx context.Context, opts ...ai.ClientOption) (ai.AI, error) {
 	for _, i := range opts {
 		i.Apply(cfg)
 	}
-	cc := &genai.ClientConfig{APIKey: cfg.APIKey}
-	if cfg.Proxy != "" {
-		u, err := url.Parse(cfg.Proxy)
-		if err != nil {
-			return nil, err
-		}
-		if t, ok := http.DefaultTransport.(*http.Transport); ok {
-			t = t.Clone()
-			t.Proxy = http.ProxyURL(u)
-			cc.HTTPClient = &http.Client{Transport: t}
-		}
+	httpClient, err := ai.NewHTTPClient(*cfg)
+	if err != nil {
+		return nil, err
 	}
+	cc := &genai.ClientConfig{APIKey: cfg.APIKey, HTTPClient: httpClient}
 	if cfg.Endpoint != "" {
 		cc.HTTPOptions.BaseURL = cfg.Endpoint
 	}
@@ -54,10 +94,12 @@ func New(ctx context.Context, opts ...ai.ClientOption) (ai.AI, error) {
 		return nil, err
 	}
 	c := NewWithClient(client, cfg.Model)
-	if cfg.Limit != nil {
-		c.SetLimit(*cfg.Limit)
-	}
+	c.(*Gemini).SetEmbeddingConfig(cfg.Embedding)
+	ai.ApplyLimits(c, *cfg)
 	ai.ApplyModelConfig(c, cfg.ModelConfig)
+	if cfg.Retry != nil {
+		return ai.Retry(c, *cfg.Retry), nil
+	}
 	return c, nil
 }
 
@@ -68,7 +110,7 @@ func NewWithClient(client *genai.Client, model string) ai.AI {
 	return &Gemini{Client: client, model: model, config: new(genai.GenerateContentConfig)}
 }
 
-func (Gemini) LLMs() ai.LLMs {
+func (*Gemini) LLMs() ai.LLMs {
 	return ai.Gemini
 }
 
@@ -76,74 +118,56 @@ func (gemini *Gemini) Model() string {
 	return gemini.model
 }
 
-func (gemini *Gemini) SetLimit(rpm int64) {
-	gemini.limiter = ai.NewLimiter(rpm)
-}
-
-func (gemini *Gemini) Limit() (rpm int64) {
-	if gemini.limiter == nil {
-		return math.MaxInt64
+// wait acquires the limits for a request, estimating the tokens of the system instruction,
+// the history and the parts.
+func (gemini *Gemini) wait(ctx context.Context, history []ai.Content, parts ...ai.Part) (ai.Release, error) {
+	if err := errors.Join(gemini.systemErr, gemini.toolsErr, gemini.schemaErr); err != nil {
+		return nil, err
 	}
-	return int64(gemini.limiter.Limit() / rate.Every(time.Minute))
-}
-
-func (ai *Gemini) wait(ctx context.Context) error {
-	if ai.limiter != nil {
-		return ai.limiter.Wait(ctx)
+	tokens := ai.EstimateTokens(parts...) + ai.EstimateHistory(history)
+	if system := gemini.config.SystemInstruction; system != nil {
+		tokens += ai.EstimateTokens(fromParts(system.Parts)...)
 	}
-	return nil
+	return gemini.Acquire(ctx, tokens)
 }
 
 func (ai *Gemini) SetModel(model string) {
 	ai.model = model
+	if ai.thinking != nil {
+		ai.config.ThinkingConfig = ai.thinkingConfig(*ai.thinking)
+	}
 }
 
-func genaiSchema(schema *ai.Schema) (*genai.Schema, error) {
-	if schema == nil {
-		return nil, nil
+func (ai *Gemini) SetSystemInstruction(parts ...ai.Part) {
+	if ai.systemErr = nil; len(parts) == 0 {
+		ai.config.SystemInstruction = nil
+		return
 	}
-	p, err := genaiProperties(schema.Properties)
+	p, err := toParts(parts)
 	if err != nil {
-		return nil, err
-	}
-	var items *genai.Schema
-	if schema.Items != nil {
-		p, err := genaiProperties(schema.Items.Properties)
-		if err != nil {
-			return nil, err
-		}
-		items = &genai.Schema{
-			Type:       genaiType(schema.Items.Type),
-			Properties: p,
-			Enum:       schema.Items.Enum,
-			Required:   schema.Items.Required,
-		}
+		ai.systemErr = err
+		return
 	}
-	return &genai.Schema{
-		Type:       genaiType(schema.Type),
-		Properties: p,
-		Enum:       schema.Enum,
-		Items:      items,
-		Required:   schema.Required,
-	}, nil
+	ai.config.SystemInstruction = genai.NewContentFromParts(p, genai.RoleUser)
 }
 
 func (gemini *Gemini) SetFunctionCall(f []ai.Function, mode ai.FunctionCallingMode) {
-	if len(f) == 0 {
+	if gemini.toolsErr = nil; len(f) == 0 {
 		gemini.config.Tools = nil
 		gemini.config.ToolConfig = nil
 		return
 	}
 	var declarations []*genai.FunctionDeclaration
 	for _, i := range f {
-		schema, err := genaiSchema(&i.Parameters)
+		schema, err := jsonSchema(i.Parameters)
 		if err != nil {
+			gemini.toolsErr = errors.Join(gemini.toolsErr, fmt.Errorf("gemini: function %q: %w", i.Name, err))
 			continue
 		}
 		declarations = append(declarations, &genai.FunctionDeclaration{
-			Name:        i.Name,
-			Description: i.Description,
-			Parameters:  schema,
+			Name:                 i.Name,
+			Description:          i.Description,
+			ParametersJsonSchema: schema,
 		})
 	}
 	gemini.config.Tools = []*genai.Tool{{FunctionDeclarations: declarations}}
@@ -169,25 +193,61 @@ func (ai *Gemini) SetMaxTokens(i int64)     { ai.config.MaxOutputTokens = int32(
 func (ai *Gemini) SetTemperature(f float64) { ai.config.Temperature = genai.Ptr(float32(f)) }
 func (ai *Gemini) SetTopP(f float64)        { ai.config.TopP = genai.Ptr(float32(f)) }
 func (ai *Gemini) SetJSONResponse(set bool, schema *ai.JSONSchema) {
+	ai.schemaErr = nil
 	if set {
 		ai.config.ResponseMIMEType = "application/json"
 		if schema != nil {
-			ai.config.ResponseSchema, _ = genaiSchema(&schema.Schema)
+			var err error
+			if ai.config.ResponseJsonSchema, err = jsonSchema(schema.Schema); err != nil {
+				ai.schemaErr = fmt.Errorf("gemini: JSON schema %q: %w", schema.Name, err)
+			}
 		} else {
-			ai.config.ResponseSchema = nil
+			ai.config.ResponseJsonSchema = nil
 		}
 	} else {
 		ai.config.ResponseMIMEType = "text/plain"
-		ai.config.ResponseSchema = nil
+		ai.config.ResponseJsonSchema = nil
 	}
 }
 func (ai *Gemini) SetThinking(set bool) {
+	ai.thinking = nil
 	if set {
 		ai.config.ThinkingConfig = &genai.ThinkingConfig{IncludeThoughts: true}
 	} else {
 		ai.config.ThinkingConfig = nil
 	}
 }
+func (gemini *Gemini) SetThinkingConfig(cfg ai.ThinkingConfig) {
+	gemini.thinking = &cfg
+	gemini.config.ThinkingConfig = gemini.thinkingConfig(cfg)
+}
+
+// thinkingLevel reports whether the model takes a thinking level, as Gemini 3 models do.
+// Earlier models only take a thinking budget.
+func thinkingLevel(model string) bool {
+	model = strings.TrimPrefix(model, "models/")
+	return !strings.HasPrefix(model, "gemini-1") && !strings.HasPrefix(model, "gemini-2")
+}
+
+func (gemini *Gemini) thinkingConfig(cfg ai.ThinkingConfig) *genai.ThinkingConfig {
+	config := &genai.ThinkingConfig{IncludeThoughts: true}
+	if cfg.Budget == 0 && cfg.Effort == 0 {
+		return config
+	}
+	if cfg.Budget == 0 && thinkingLevel(gemini.model) {
+		switch cfg.Effort {
+		case ai.ThinkingLow:
+			config.ThinkingLevel = genai.ThinkingLevelLow
+		case ai.ThinkingMedium:
+			config.ThinkingLevel = genai.ThinkingLevelMedium
+		case ai.ThinkingHigh:
+			config.ThinkingLevel = genai.ThinkingLevelHigh
+		}
+	} else {
+		config.ThinkingBudget = genai.Ptr(int32(min(cfg.BudgetTokens(), math.MaxInt32)))
+	}
+	return config
+}
 
 func (ai *Gemini) ListModels(ctx context.Context) ([]string, error) {
 	var models []string
@@ -200,9 +260,82 @@ func (ai *Gemini) ListModels(ctx context.Context) ([]string, error) {
 	return models, nil
 }
 
-func toParts(src []ai.Part) (dst []*genai.Part) {
+func (ai *Gemini) SetEmbeddingConfig(cfg ai.EmbeddingConfig) { ai.embedding = cfg }
+
+func (gemini *Gemini) Embed(ctx context.Context, texts ...string) ([][]float32, ai.TokenCount, error) {
+	if gemini.Client == nil {
+		return nil, ai.TokenCount{}, ai.ErrAIClosed
+	}
+	model := gemini.embedding.Model
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+	config := &genai.EmbedContentConfig{TaskType: embeddingTask(gemini.embedding.Task)}
+	if gemini.embedding.Dimensions > 0 {
+		config.OutputDimensionality = genai.Ptr(int32(min(gemini.embedding.Dimensions, math.MaxInt32)))
+	}
+	return ai.EmbedBatches(ctx, texts, EmbeddingBatchSize, func(ctx context.Context, batch []string) ([][]float32, ai.TokenCount, error) {
+		var parts []ai.Part
+		var contents []*genai.Content
+		for _, i := range batch {
+			parts = append(parts, ai.Text(i))
+			contents = append(contents, genai.NewContentFromText(i, genai.RoleUser))
+		}
+		release, err := gemini.Acquire(ctx, ai.EstimateTokens(parts...))
+		if err != nil {
+			return nil, ai.TokenCount{}, err
+		}
+		// Token statistics are only returned by Vertex AI, leaving the estimate reserved otherwise.
+		var usage ai.TokenCount
+		defer func() { release.Done(usage) }()
+		resp, err := gemini.Models.EmbedContent(ctx, model, contents, config)
+		if err != nil {
+			return nil, ai.TokenCount{}, err
+		}
+		var vectors [][]float32
+		for _, i := range resp.Embeddings {
+			vectors = append(vectors, i.Values)
+			if i.Statistics != nil {
+				usage.Prompt += int64(i.Statistics.TokenCount)
+			}
+		}
+		usage.Total = usage.Prompt
+		return vectors, usage, nil
+	})
+}
+
+func embeddingTask(task ai.EmbeddingTask) string {
+	switch task {
+	case ai.EmbeddingRetrievalQuery:
+		return "RETRIEVAL_QUERY"
+	case ai.EmbeddingRetrievalDocument:
+		return "RETRIEVAL_DOCUMENT"
+	case ai.EmbeddingSemanticSimilarity:
+		return "SEMANTIC_SIMILARITY"
+	case ai.EmbeddingClassification:
+		return "CLASSIFICATION"
+	case ai.EmbeddingClustering:
+		return "CLUSTERING"
+	}
+	return ""
+}
+
+// toParts converts src, attaching the signature of a thought without text
+// to the part which follows it, where fromParts found it.
+func toParts(src []ai.Part) (dst []*genai.Part, err error) {
+	var signature []byte
 	for _, i := range src {
+		n := len(dst)
 		switch v := i.(type) {
+		case ai.Thought:
+			// Thought signatures of other providers are rejected.
+			if v.Provider != ai.Gemini {
+				continue
+			} else if v.Text == "" {
+				signature = v.Signature
+				continue
+			}
+			dst = append(dst, &genai.Part{Text: v.Text, Thought: true, ThoughtSignature: v.Signature})
 		case ai.Text:
 			dst = append(dst, genai.NewPartFromText(string(v)))
 		case ai.Image:
@@ -211,28 +344,77 @@ func toParts(src []ai.Part) (dst []*genai.Part) {
 		case ai.Blob:
 			dst = append(dst, genai.NewPartFromBytes(v.Data, v.MIMEType))
 		case ai.FunctionCall:
-			b, err := json.Marshal(v.Arguments)
-			if err != nil {
-				panic(err)
-			}
 			var args map[string]any
-			if err := json.Unmarshal(b, &args); err != nil {
-				panic(err)
+			if v.Arguments != "" {
+				if err = json.Unmarshal([]byte(v.Arguments), &args); err != nil {
+					return nil, fmt.Errorf("gemini: arguments of function call %q: %w", v.Name, err)
+				}
 			}
-			dst = append(dst, genai.NewPartFromFunctionCall(v.Name, args))
+			call := &genai.FunctionCall{Name: v.Name, Args: args}
+			if v.ID != v.Name {
+				call.ID = v.ID
+			}
+			dst = append(dst, &genai.Part{FunctionCall: call})
 		case ai.FunctionResponse:
-			var resp map[string]any
-			if err := json.Unmarshal([]byte(v.Response), &resp); err != nil {
-				panic(err)
+			name := v.Name
+			if name == "" {
+				name = v.ID
 			}
-			dst = append(dst, genai.NewPartFromFunctionResponse(v.ID, resp))
+			resp := &genai.FunctionResponse{Name: name, Response: functionResponse(v.Response)}
+			if v.ID != name {
+				resp.ID = v.ID
+			}
+			dst = append(dst, &genai.Part{FunctionResponse: resp})
+		default:
+			return nil, fmt.Errorf("gemini: unsupported part type %T", i)
+		}
+		if signature != nil && len(dst) > n {
+			dst[n].ThoughtSignature, signature = signature, nil
+		}
+	}
+	if signature != nil {
+		dst = append(dst, &genai.Part{ThoughtSignature: signature})
+	}
+	return
+}
+
+// functionResponse wraps responses which are not JSON objects, as gemini requires one.
+func functionResponse(s string) map[string]any {
+	var resp map[string]any
+	if err := json.Unmarshal([]byte(s), &resp); err == nil && resp != nil {
+		return resp
+	}
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		v = s
+	}
+	return map[string]any{"result": v}
+}
+
+func toContents(history []ai.Content) (contents []*genai.Content, err error) {
+	for _, i := range history {
+		parts, err := toParts(i.Parts)
+		if err != nil {
+			return nil, err
 		}
+		role := genai.RoleUser
+		switch i.Role {
+		case "assistant", genai.RoleModel:
+			role = genai.RoleModel
+		}
+		contents = append(contents, genai.NewContentFromParts(parts, genai.Role(role)))
 	}
 	return
 }
 
 func fromParts(src []*genai.Part) (dst []ai.Part) {
 	for _, i := range src {
+		if i.Thought {
+			dst = append(dst, ai.Thought{Text: i.Text, Signature: i.ThoughtSignature, Provider: ai.Gemini})
+			continue
+		} else if len(i.ThoughtSignature) > 0 {
+			dst = append(dst, ai.Thought{Signature: i.ThoughtSignature, Provider: ai.Gemini})
+		}
 		if i.Text != "" {
 			dst = append(dst, ai.Text(i.Text))
 		} else if i.InlineData != nil {
@@ -252,7 +434,11 @@ func fromParts(src []*genai.Part) (dst []ai.Part) {
 			if err != nil {
 				panic(err)
 			}
-			dst = append(dst, ai.FunctionResponse{ID: i.FunctionResponse.ID, Response: string(b)})
+			id := i.FunctionResponse.ID
+			if id == "" {
+				id = i.FunctionResponse.Name
+			}
+			dst = append(dst, ai.FunctionResponse{ID: id, Name: i.FunctionResponse.Name, Response: string(b)})
 		}
 	}
 	return
@@ -319,11 +505,22 @@ func (resp *ChatResponse) FunctionCalls() (res []ai.FunctionCall) {
 	return
 }
 
-func (resp *ChatResponse) TokenCount() (res ai.TokenCount) {
+func (resp *ChatResponse) TokenCount() ai.TokenCount {
+	return tokenCount(resp.GenerateContentResponse)
+}
+
+func tokenCount(resp *genai.GenerateContentResponse) (res ai.TokenCount) {
+	if resp == nil {
+		return
+	}
+	// Thoughts and tool use prompts are counted apart from candidates and prompt by Gemini.
 	if usage := resp.UsageMetadata; usage != nil {
-		res.Prompt = int64(usage.PromptTokenCount)
-		res.Result = int64(usage.CandidatesTokenCount)
+		res.Prompt = int64(usage.PromptTokenCount + usage.ToolUsePromptTokenCount)
+		res.Result = int64(usage.CandidatesTokenCount + usage.ThoughtsTokenCount)
 		res.Total = int64(usage.TotalTokenCount)
+		res.Cached = int64(usage.CachedContentTokenCount)
+		res.ToolUse = int64(usage.ToolUsePromptTokenCount)
+		res.Reasoning = int64(usage.ThoughtsTokenCount)
 	}
 	return
 }
@@ -343,15 +540,21 @@ func (resp *ChatResponse) String() string {
 }
 
 func (ai *Gemini) Chat(ctx context.Context, parts ...ai.Part) (ai.ChatResponse, error) {
-	if err := ai.wait(ctx); err != nil {
+	p, err := toParts(parts)
+	if err != nil {
+		return nil, err
+	}
+	release, err := ai.wait(ctx, nil, parts...)
+	if err != nil {
 		return nil, err
 	}
 	resp, err := ai.Models.GenerateContent(
 		ctx,
 		ai.model,
-		[]*genai.Content{genai.NewContentFromParts(toParts(parts), genai.RoleUser)},
+		[]*genai.Content{genai.NewContentFromParts(p, genai.RoleUser)},
 		ai.config,
 	)
+	release.Done(tokenCount(resp))
 	if err != nil {
 		return nil, err
 	}
@@ -382,16 +585,21 @@ func (stream *ChatStream) Close() error {
 }
 
 func (ai *Gemini) ChatStream(ctx context.Context, parts ...ai.Part) (ai.ChatStream, error) {
-	if err := ai.wait(ctx); err != nil {
+	p, err := toParts(parts)
+	if err != nil {
+		return nil, err
+	}
+	release, err := ai.wait(ctx, nil, parts...)
+	if err != nil {
 		return nil, err
 	}
 	next, stop := iter.Pull2(ai.Models.GenerateContentStream(
 		ctx,
 		ai.model,
-		[]*genai.Content{genai.NewContentFromParts(toParts(parts), genai.RoleUser)},
+		[]*genai.Content{genai.NewContentFromParts(p, genai.RoleUser)},
 		ai.config,
 	))
-	return &ChatStream{next, stop}, nil
+	return release.Stream(&ChatStream{next, stop}), nil
 }
 
 var _ ai.ChatSession = new(ChatSession)
@@ -402,10 +610,16 @@ type ChatSession struct {
 }
 
 func (session *ChatSession) Chat(ctx context.Context, parts ...ai.Part) (ai.ChatResponse, error) {
-	if err := session.ai.wait(ctx); err != nil {
+	p, err := toParts(parts)
+	if err != nil {
 		return nil, err
 	}
-	resp, err := session.cs.Send(ctx, toParts(parts)...)
+	release, err := session.ai.wait(ctx, session.History(), parts...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := session.cs.Send(ctx, p...)
+	release.Done(tokenCount(resp))
 	if err != nil {
 		return nil, err
 	}
@@ -413,20 +627,70 @@ func (session *ChatSession) Chat(ctx context.Context, parts ...ai.Part) (ai.Chat
 }
 
 func (session *ChatSession) ChatStream(ctx context.Context, parts ...ai.Part) (ai.ChatStream, error) {
-	if err := session.ai.wait(ctx); err != nil {
+	p, err := toParts(parts)
+	if err != nil {
 		return nil, err
 	}
-	next, stop := iter.Pull2(session.cs.SendStream(ctx, toParts(parts)...))
-	return &ChatStream{next, stop}, nil
+	release, err := session.ai.wait(ctx, session.History(), parts...)
+	if err != nil {
+		return nil, err
+	}
+	next, stop := iter.Pull2(session.cs.SendStream(ctx, p...))
+	return release.Stream(&ChatStream{next, stop}), nil
 }
 
 func (session *ChatSession) History() (history []ai.Content) {
 	for _, i := range session.cs.History(false) {
-		history = append(history, ai.Content{Parts: fromParts(i.Parts), Role: i.Role})
+		if parts := fromParts(i.Parts); len(parts) > 0 {
+			history = append(history, ai.Content{Parts: parts, Role: i.Role})
+		}
 	}
 	return
 }
 
+func (session *ChatSession) SetHistory(history []ai.Content) error {
+	contents, err := toContents(ai.NormalizeHistory(history))
+	if err != nil {
+		return err
+	}
+	chat, err := session.ai.Chats.Create(context.Background(), session.ai.model, session.ai.config, contents)
+	if err != nil {
+		return err
+	}
+	session.cs = chat
+	return nil
+}
+
+func (session *ChatSession) SnapshotHistory() func() {
+	history := slices.Clone(session.cs.History(false))
+	return func() {
+		if len(session.cs.History(false)) == len(history) {
+			return
+		}
+		if chat, err := session.ai.Chats.Create(context.Background(), session.ai.model, session.ai.config, history); err == nil {
+			session.cs = chat
+		}
+	}
+}
+
+func (gemini *Gemini) CountTokens(ctx context.Context, parts ...ai.Part) (int64, error) {
+	if gemini.Client == nil {
+		return 0, ai.ErrAIClosed
+	}
+	if len(parts) == 0 {
+		return 0, nil
+	}
+	p, err := toParts(parts)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := gemini.Models.CountTokens(ctx, gemini.model, []*genai.Content{genai.NewContentFromParts(p, genai.RoleUser)}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int64(resp.TotalTokens), nil
+}
+
 func (ai *Gemini) ChatSession() ai.ChatSession {
 	chat, _ := ai.Chats.Create(context.Background(), ai.model, ai.config, nil)
 	return &ChatSession{ai, chat}
@@ -0,0 +1,260 @@
+package gemini
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sunshineplan/ai"
+	"github.com/sunshineplan/ai/aitest"
+
+	"google.golang.org/genai"
+)
+
+func TestContents(t *testing.T) {
+	history := []ai.Content{
+		{Role: "user", Parts: []ai.Part{ai.Text("What is it?"), ai.Blob{MIMEType: "image/png", Data: []byte{1, 2, 3}}}},
+		{Role: "model", Parts: []ai.Part{ai.FunctionCall{ID: "describe", Name: "describe", Arguments: `{"detail":true}`}}},
+		{Role: "user", Parts: []ai.Part{ai.FunctionResponse{ID: "describe", Name: "describe", Response: `{"result":"a cat"}`}}},
+	}
+	contents, err := toContents(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []ai.Content
+	for _, i := range contents {
+		res = append(res, ai.Content{Parts: fromParts(i.Parts), Role: i.Role})
+	}
+	if !reflect.DeepEqual(history, res) {
+		t.Errorf("expected %v; got %v", history, res)
+	}
+	contents, err = toContents([]ai.Content{{Role: "user", Parts: []ai.Part{ai.FunctionResponse{ID: "1", Name: "describe", Response: "a cat"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := contents[0].Parts[0].FunctionResponse; resp.ID != "1" || resp.Name != "describe" || resp.Response["result"] != "a cat" {
+		t.Errorf("unexpected function response: %#v", resp)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := aitest.NewGeminiServer(
+		aitest.Reply{Results: []string{"Hello"}, TokenCount: ai.TokenCount{Prompt: 10, Result: 4, Total: 14, Cached: 6, ToolUse: 2, Reasoning: 1}},
+		aitest.Reply{Chunks: []aitest.Reply{
+			{Results: []string{"Let me "}},
+			{Results: []string{"check."}},
+			{FunctionCalls: []ai.FunctionCall{{Name: "weather", Arguments: `{"city":"Paris"}`}}, TokenCount: ai.TokenCount{Prompt: 5, Result: 5, Total: 10, Cached: 3, Reasoning: 2}},
+		}},
+		aitest.Error(&aitest.StatusError{StatusCode: 429, Code: "RESOURCE_EXHAUSTED", Message: "quota"}),
+	)
+	defer s.Close()
+	c, err := New(t.Context(), ai.WithAPIKey("test"), ai.WithEndpoint(s.URL), ai.WithModel("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Chat(t.Context(), ai.Text("Hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"Hello"}) {
+		t.Errorf("expected [Hello]; got %q", res)
+	}
+	if tokens, expect := resp.TokenCount(), (ai.TokenCount{Prompt: 10, Result: 4, Total: 14, Cached: 6, ToolUse: 2, Reasoning: 1}); tokens != expect {
+		t.Errorf("expected %+v; got %+v", expect, tokens)
+	}
+
+	session := c.ChatSession()
+	stream, err := session.ChatStream(t.Context(), ai.Text("Weather in Paris?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var tokens ai.TokenCount
+	for {
+		resp, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if resp.TokenCount().Total > 0 {
+			tokens = resp.TokenCount()
+		}
+	}
+	if expect := (ai.TokenCount{Prompt: 5, Result: 5, Total: 10, Cached: 3, Reasoning: 2}); tokens != expect {
+		t.Errorf("expected %+v; got %+v", expect, tokens)
+	}
+	// Each chunk of a stream is recorded as a model content.
+	history := session.History()
+	if n := len(history); n != 4 {
+		t.Fatalf("expected 4 contents; got %d", n)
+	}
+	var text string
+	var calls []ai.FunctionCall
+	for _, i := range history[1:] {
+		for _, i := range i.Parts {
+			switch v := i.(type) {
+			case ai.Text:
+				text += string(v)
+			case ai.FunctionCall:
+				calls = append(calls, v)
+			}
+		}
+	}
+	if text != "Let me check." || len(calls) != 1 || calls[0].Name != "weather" {
+		t.Errorf("unexpected history %v", history[1:])
+	}
+
+	_, err = c.Chat(t.Context(), ai.Text("Hi"))
+	if info, ok := ai.ClassifyError(err); !ok || info.StatusCode != 429 {
+		t.Errorf("expected 429 error; got %v", err)
+	}
+}
+
+func TestThinkingHistory(t *testing.T) {
+	s := aitest.NewGeminiServer(
+		aitest.Reply{Thoughts: []string{"Use the weather tool."}, FunctionCalls: []ai.FunctionCall{{Name: "weather", Arguments: `{"city":"Paris"}`}}},
+		aitest.Text("Sunny"),
+	)
+	defer s.Close()
+	c, err := New(t.Context(), ai.WithAPIKey("test"), ai.WithEndpoint(s.URL), ai.WithModel("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetThinking(true)
+	session := c.ChatSession()
+	if _, err := session.Chat(t.Context(), ai.Text("Weather in Paris?")); err != nil {
+		t.Fatal(err)
+	}
+	history := session.History()
+	if n := len(history); n != 2 {
+		t.Fatalf("expected 2 contents; got %d", n)
+	}
+	parts := history[1].Parts
+	if n := len(parts); n != 3 {
+		t.Fatalf("expected 3 parts; got %v", parts)
+	}
+	if thought, ok := parts[0].(ai.Thought); !ok || thought.Text != "Use the weather tool." {
+		t.Errorf("expected thought; got %v", parts[0])
+	}
+	signature, ok := parts[1].(ai.Thought)
+	if !ok || signature.Text != "" || len(signature.Signature) == 0 {
+		t.Fatalf("expected thought signature; got %v", parts[1])
+	}
+
+	restored := c.ChatSession()
+	if err := restored.SetHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Chat(t.Context(), ai.FunctionResponse{ID: "weather", Name: "weather", Response: `{"weather":"sunny"}`}); err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		Contents []struct {
+			Role  string
+			Parts []struct {
+				Text             string
+				Thought          bool
+				ThoughtSignature []byte
+				FunctionCall     *struct{ Name string }
+			}
+		}
+	}
+	if err := s.Requests()[1].JSON(&req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(req.Contents); n != 3 {
+		t.Fatalf("expected 3 contents; got %d", n)
+	}
+	model := req.Contents[1].Parts
+	if n := len(model); n != 2 {
+		t.Fatalf("expected 2 model parts; got %d", n)
+	}
+	if !model[0].Thought || model[0].Text != "Use the weather tool." {
+		t.Errorf("expected thought part; got %+v", model[0])
+	}
+	if model[1].FunctionCall == nil || string(model[1].ThoughtSignature) != string(signature.Signature) {
+		t.Errorf("expected signed function call; got %+v", model[1])
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	defer func(n int) { EmbeddingBatchSize = n }(EmbeddingBatchSize)
+	EmbeddingBatchSize = 2
+	s := aitest.NewGeminiServer()
+	defer s.Close()
+	c, err := New(
+		t.Context(),
+		ai.WithAPIKey("test"),
+		ai.WithEndpoint(s.URL),
+		ai.WithEmbeddingConfig(ai.EmbeddingConfig{Dimensions: 4, Task: ai.EmbeddingRetrievalDocument}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{"a", "b", "c"}
+	vectors, _, err := c.(ai.Embedder).Embed(t.Context(), texts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range texts {
+		if expect := aitest.Embedding(text, 4); !reflect.DeepEqual(vectors[i], expect) {
+			t.Errorf("#%d: expected %v; got %v", i, expect, vectors[i])
+		}
+	}
+	if n := len(s.Requests()); n != 2 {
+		t.Fatalf("expected 2 requests; got %d", n)
+	}
+	var req struct {
+		Requests []struct {
+			Model                string
+			TaskType             string
+			OutputDimensionality int
+		}
+	}
+	if err := s.Requests()[0].JSON(&req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(req.Requests); n != 2 {
+		t.Fatalf("expected 2 texts in first batch; got %d", n)
+	}
+	if r := req.Requests[0]; r.Model != "models/"+defaultEmbeddingModel || r.TaskType != "RETRIEVAL_DOCUMENT" || r.OutputDimensionality != 4 {
+		t.Errorf("unexpected request %+v", r)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	s := aitest.NewGeminiServer()
+	defer s.Close()
+	c, err := New(t.Context(), ai.WithAPIKey("test"), ai.WithEndpoint(s.URL), ai.WithModel("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.CountTokens(t.Context(), ai.Text("Hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 tokens; got %d", n)
+	}
+	if path := s.Requests()[0].Path; !strings.HasSuffix(path, "models/test:countTokens") {
+		t.Errorf("unexpected path %q", path)
+	}
+}
+
+func TestThinkingConfig(t *testing.T) {
+	c := &Gemini{model: "gemini-2.5-flash", config: new(genai.GenerateContentConfig)}
+	c.SetThinkingConfig(ai.ThinkingConfig{Effort: ai.ThinkingLow})
+	if config := c.config.ThinkingConfig; config.ThinkingBudget == nil || *config.ThinkingBudget != 2048 || config.ThinkingLevel != "" {
+		t.Errorf("expected thinking budget 2048; got %+v", config)
+	}
+	c.SetModel("gemini-3-pro-preview")
+	if config := c.config.ThinkingConfig; config.ThinkingBudget != nil || config.ThinkingLevel != genai.ThinkingLevelLow {
+		t.Errorf("expected low thinking level; got %+v", config)
+	}
+	c.SetThinkingConfig(ai.ThinkingConfig{Budget: 4096})
+	if config := c.config.ThinkingConfig; config.ThinkingBudget == nil || *config.ThinkingBudget != 4096 {
+		t.Errorf("expected thinking budget 4096; got %+v", config)
+	}
+}
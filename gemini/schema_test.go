@@ -0,0 +1,51 @@
+package gemini
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sunshineplan/ai"
+
+	"google.golang.org/genai"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema := ai.SchemaFor[struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	}]()
+	schema.Defs = map[string]*ai.Schema{"label": {Type: "string"}}
+	schema.Properties["label"] = &ai.Schema{Ref: "#/$defs/label"}
+	c := &Gemini{config: new(genai.GenerateContentConfig)}
+	c.SetFunctionCall([]ai.Function{{Name: "label", Parameters: schema}, {Name: "list"}}, ai.FunctionCallingAuto)
+	if c.toolsErr != nil {
+		t.Fatal(c.toolsErr)
+	}
+	declarations := c.config.Tools[0].FunctionDeclarations
+	b, err := json.Marshal(declarations[0].ParametersJsonSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res ai.Schema
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if labels := res.Properties["labels"]; labels == nil || labels.AdditionalProperties == nil {
+		t.Errorf("expected labels with additionalProperties; got %s", b)
+	}
+	if label := res.Properties["label"]; label == nil || label.Ref != "#/$defs/label" || res.Defs["label"] == nil {
+		t.Errorf("expected label referring to $defs; got %s", b)
+	}
+	if declarations[1].ParametersJsonSchema != nil {
+		t.Errorf("expected no parameters; got %v", declarations[1].ParametersJsonSchema)
+	}
+	c.SetJSONResponse(true, &ai.JSONSchema{Name: "labels", Schema: schema})
+	if c.schemaErr != nil {
+		t.Fatal(c.schemaErr)
+	} else if c.config.ResponseJsonSchema == nil {
+		t.Error("expected response JSON schema; got nil")
+	}
+	if _, err := jsonSchema(ai.Schema{Nullable: true}); err == nil {
+		t.Error("expected error for invalid schema; got nil")
+	}
+}
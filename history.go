@@ -0,0 +1,75 @@
+package ai
+
+import (
+	"regexp"
+	"strconv"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+var validCallID = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// NormalizeHistory converts history taken from any provider into a provider-neutral form,
+// so that a conversation can be continued on another provider with ChatSession.SetHistory.
+//
+// Roles are normalized to RoleUser, RoleAssistant and RoleTool, with function responses
+// split out of user contents into tool contents. Every function call gets an ID which is
+// unique within the history and accepted by all providers, and function responses are
+// updated to refer to it, since some providers identify calls by function name only.
+func NormalizeHistory(history []Content) (res []Content) {
+	seen := make(map[string]bool)
+	pending := make(map[string][]FunctionCall)
+	var n int
+	newID := func() string {
+		for {
+			n++
+			if id := "call_" + strconv.Itoa(n); !seen[id] {
+				return id
+			}
+		}
+	}
+	for _, c := range history {
+		switch c.Role {
+		case "assistant", "model":
+			content := Content{Role: RoleAssistant}
+			for _, i := range c.Parts {
+				if v, ok := i.(FunctionCall); ok {
+					id := v.ID
+					if !validCallID.MatchString(id) || seen[id] {
+						v.ID = newID()
+					}
+					seen[v.ID] = true
+					pending[id] = append(pending[id], v)
+					i = v
+				}
+				content.Parts = append(content.Parts, i)
+			}
+			if len(content.Parts) > 0 {
+				res = append(res, content)
+			}
+		default:
+			tool, user := Content{Role: RoleTool}, Content{Role: RoleUser}
+			for _, i := range c.Parts {
+				if v, ok := i.(FunctionResponse); ok {
+					if calls := pending[v.ID]; len(calls) > 0 {
+						pending[v.ID] = calls[1:]
+						v.ID, v.Name = calls[0].ID, calls[0].Name
+					}
+					tool.Parts = append(tool.Parts, v)
+				} else {
+					user.Parts = append(user.Parts, i)
+				}
+			}
+			for _, i := range []Content{tool, user} {
+				if len(i.Parts) > 0 {
+					res = append(res, i)
+				}
+			}
+		}
+	}
+	return
+}
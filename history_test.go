@@ -0,0 +1,40 @@
+package ai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeHistory(t *testing.T) {
+	history := []Content{
+		{Role: "user", Parts: []Part{Text("weather?")}},
+		{Role: "model", Parts: []Part{
+			FunctionCall{ID: "weather", Name: "weather", Arguments: `{"city":"a"}`},
+			FunctionCall{ID: "weather", Name: "weather", Arguments: `{"city":"b"}`},
+		}},
+		{Role: "user", Parts: []Part{
+			FunctionResponse{ID: "weather", Response: `{"result":"sunny"}`},
+			FunctionResponse{ID: "weather", Response: `{"result":"rainy"}`},
+			Text("and tomorrow?"),
+		}},
+		{Role: "model", Parts: []Part{FunctionCall{ID: "weather", Name: "weather"}, FunctionCall{ID: "bad id", Name: "time"}}},
+		{Role: "tool", Parts: []Part{FunctionResponse{ID: "weather"}, FunctionResponse{ID: "bad id"}}},
+	}
+	expect := []Content{
+		{Role: RoleUser, Parts: []Part{Text("weather?")}},
+		{Role: RoleAssistant, Parts: []Part{
+			FunctionCall{ID: "weather", Name: "weather", Arguments: `{"city":"a"}`},
+			FunctionCall{ID: "call_1", Name: "weather", Arguments: `{"city":"b"}`},
+		}},
+		{Role: RoleTool, Parts: []Part{
+			FunctionResponse{ID: "weather", Name: "weather", Response: `{"result":"sunny"}`},
+			FunctionResponse{ID: "call_1", Name: "weather", Response: `{"result":"rainy"}`},
+		}},
+		{Role: RoleUser, Parts: []Part{Text("and tomorrow?")}},
+		{Role: RoleAssistant, Parts: []Part{FunctionCall{ID: "call_2", Name: "weather"}, FunctionCall{ID: "call_3", Name: "time"}}},
+		{Role: RoleTool, Parts: []Part{FunctionResponse{ID: "call_2", Name: "weather"}, FunctionResponse{ID: "call_3", Name: "time"}}},
+	}
+	if res := NormalizeHistory(history); !reflect.DeepEqual(res, expect) {
+		t.Errorf("expected %v; got %v", expect, res)
+	}
+}
@@ -0,0 +1,63 @@
+package ai
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// NewHTTPClient returns the HTTP client described by cfg,
+// or nil if the default client of the provider should be used.
+//
+// The client is a copy of cfg.HTTPClient, if set, using cfg.Transport, if set, as its transport.
+// Proxy applies to the transport if it is an *http.Transport, Header is added to every request,
+// and Timeout replaces the timeout of the client if set.
+func NewHTTPClient(cfg ClientConfig) (*http.Client, error) {
+	if cfg.HTTPClient == nil && cfg.Proxy == "" && cfg.Transport == nil && len(cfg.Header) == 0 && cfg.Timeout == 0 {
+		return nil, nil
+	}
+	client := new(http.Client)
+	if cfg.HTTPClient != nil {
+		*client = *cfg.HTTPClient
+	}
+	if cfg.Transport != nil {
+		client.Transport = cfg.Transport
+	}
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		if client.Transport == nil {
+			client.Transport = http.DefaultTransport
+		}
+		if t, ok := client.Transport.(*http.Transport); ok {
+			t = t.Clone()
+			t.Proxy = http.ProxyURL(u)
+			client.Transport = t
+		}
+	}
+	if len(cfg.Header) > 0 {
+		client.Transport = &headerTransport{client.Transport, cfg.Header.Clone()}
+	}
+	if cfg.Timeout > 0 {
+		client.Timeout = cfg.Timeout
+	}
+	return client, nil
+}
+
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
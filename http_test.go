@@ -0,0 +1,56 @@
+package ai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	if c, err := NewHTTPClient(ClientConfig{}); err != nil || c != nil {
+		t.Fatalf("expected nil client; got %v, %v", c, err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Org", r.Header.Get("X-Org"))
+		w.Header().Set("X-Auth", r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+	base := &http.Client{Timeout: time.Minute}
+	var cfg ClientConfig
+	for _, i := range []ClientOption{
+		WithHTTPClient(base),
+		WithHeader("x-org", "org"),
+		WithHeader("Authorization", "Bearer gateway"),
+		WithTimeout(time.Second),
+	} {
+		i.Apply(&cfg)
+	}
+	c, err := NewHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == base || base.Timeout != time.Minute || base.Transport != nil {
+		t.Error("expected base client not modified")
+	}
+	if c.Timeout != time.Second {
+		t.Errorf("expected timeout 1s; got %s", c.Timeout)
+	}
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Authorization", "Bearer key")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if org := resp.Header.Get("X-Org"); org != "org" {
+		t.Errorf("expected org; got %q", org)
+	}
+	if auth := resp.Header.Get("X-Auth"); auth != "Bearer gateway" {
+		t.Errorf("expected Bearer gateway; got %q", auth)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer key" {
+		t.Errorf("expected request not modified; got %q", auth)
+	}
+}
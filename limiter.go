@@ -1,7 +1,9 @@
 package ai
 
 import (
+	"context"
 	"math"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -10,6 +12,10 @@ import (
 type Limiter interface {
 	SetLimit(rpm int64)
 	Limit() (rpm int64)
+	SetTokenLimit(tpm int64)
+	TokenLimit() (tpm int64)
+	SetConcurrency(n int64)
+	Concurrency() (n int64)
 }
 
 func NewLimiter(rpm int64) *rate.Limiter {
@@ -18,3 +24,205 @@ func NewLimiter(rpm int64) *rate.Limiter {
 	}
 	return rate.NewLimiter(rate.Every(time.Minute)*rate.Limit(rpm), int(rpm))
 }
+
+// TokenLimiter limits tokens per minute. Requests reserve their estimated tokens up front
+// and reconcile them with the actual usage once done.
+type TokenLimiter struct {
+	mu     sync.Mutex
+	limit  int64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenLimiter(tpm int64) *TokenLimiter {
+	if tpm <= 0 || tpm == math.MaxInt64 {
+		return nil
+	}
+	return &TokenLimiter{limit: tpm, tokens: float64(tpm), last: time.Now()}
+}
+
+func (l *TokenLimiter) Limit() (tpm int64) {
+	return l.limit
+}
+
+func (l *TokenLimiter) refill(now time.Time) {
+	l.tokens = min(l.tokens+now.Sub(l.last).Minutes()*float64(l.limit), float64(l.limit))
+	l.last = now
+}
+
+// Wait blocks until n tokens are available and reserves them.
+func (l *TokenLimiter) Wait(ctx context.Context, n int64) error {
+	need := float64(min(n, l.limit))
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		if l.tokens >= need {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		d := time.Duration((need - l.tokens) / float64(l.limit) * float64(time.Minute))
+		l.mu.Unlock()
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Reconcile corrects a reservation of reserved tokens with the number of tokens actually used.
+func (l *TokenLimiter) Reconcile(reserved, used int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.tokens = min(l.tokens+float64(reserved-used), float64(l.limit))
+}
+
+// Release releases the limits reserved for a request with the token usage of the request.
+// A usage with zero Total, as after errors, is unknown and keeps the estimate reserved.
+// A nil Release does nothing.
+type Release func(TokenCount)
+
+func (r Release) Done(usage TokenCount) {
+	if r != nil {
+		r(usage)
+	}
+}
+
+// Stream returns a stream which releases the limits once it is done or closed,
+// using the largest token usage reported by its chunks.
+func (r Release) Stream(stream ChatStream) ChatStream {
+	if r == nil {
+		return stream
+	}
+	return &limitStream{ChatStream: stream, release: r}
+}
+
+type limitStream struct {
+	ChatStream
+	release Release
+	usage   TokenCount
+}
+
+func (s *limitStream) Next() (ChatResponse, error) {
+	resp, err := s.ChatStream.Next()
+	if resp != nil {
+		if usage := resp.TokenCount(); usage.Total > s.usage.Total {
+			s.usage = usage
+		}
+	}
+	if err != nil {
+		s.release.Done(s.usage)
+	}
+	return resp, err
+}
+
+func (s *limitStream) Close() error {
+	s.release.Done(s.usage)
+	return s.ChatStream.Close()
+}
+
+// Limits implements Limiter with requests per minute, tokens per minute and requests in flight.
+// Providers embed it and call Acquire before each request.
+type Limits struct {
+	mu          sync.Mutex
+	limiter     *rate.Limiter
+	tokens      *TokenLimiter
+	concurrency chan struct{}
+}
+
+func (l *Limits) SetLimit(rpm int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limiter = NewLimiter(rpm)
+}
+
+func (l *Limits) Limit() (rpm int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limiter == nil {
+		return math.MaxInt64
+	}
+	return int64(l.limiter.Limit() / rate.Every(time.Minute))
+}
+
+func (l *Limits) SetTokenLimit(tpm int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = NewTokenLimiter(tpm)
+}
+
+func (l *Limits) TokenLimit() (tpm int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tokens == nil {
+		return math.MaxInt64
+	}
+	return l.tokens.Limit()
+}
+
+func (l *Limits) SetConcurrency(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n <= 0 || n == math.MaxInt64 {
+		l.concurrency = nil
+	} else {
+		l.concurrency = make(chan struct{}, n)
+	}
+}
+
+func (l *Limits) Concurrency() (n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.concurrency == nil {
+		return math.MaxInt64
+	}
+	return int64(cap(l.concurrency))
+}
+
+// Acquire waits until a request using an estimated number of tokens can be made.
+// The returned Release must be called once the request is done.
+func (l *Limits) Acquire(ctx context.Context, tokens int64) (Release, error) {
+	l.mu.Lock()
+	limiter, tl, sem := l.limiter, l.tokens, l.concurrency
+	l.mu.Unlock()
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	unlock := func() {
+		if sem != nil {
+			<-sem
+		}
+	}
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	if tl != nil {
+		if err := tl.Wait(ctx, tokens); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	if tl == nil && sem == nil {
+		return nil, nil
+	}
+	var once sync.Once
+	return func(usage TokenCount) {
+		once.Do(func() {
+			if tl != nil && usage.Total > 0 {
+				tl.Reconcile(tokens, usage.Total)
+			}
+			unlock()
+		})
+	}, nil
+}
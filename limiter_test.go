@@ -0,0 +1,76 @@
+package ai
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	var l Limits
+	if l.Limit() != math.MaxInt64 || l.TokenLimit() != math.MaxInt64 || l.Concurrency() != math.MaxInt64 {
+		t.Fatal("expected no limits")
+	}
+	if release, err := l.Acquire(context.Background(), 100); err != nil || release != nil {
+		t.Fatalf("expected nil release; got %v", err)
+	}
+
+	l.SetConcurrency(1)
+	release, err := l.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, 0); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded; got %v", err)
+	}
+	release.Done(TokenCount{})
+	release.Done(TokenCount{})
+	if release, err = l.Acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	release.Done(TokenCount{})
+}
+
+func TestTokenLimiter(t *testing.T) {
+	l := NewTokenLimiter(1000)
+	if err := l.Wait(context.Background(), 800); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, 800); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded; got %v", err)
+	}
+	l.Reconcile(800, 100)
+	if err := l.Wait(context.Background(), 800); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownUsage(t *testing.T) {
+	var l Limits
+	l.SetTokenLimit(1000)
+	release, err := l.Acquire(context.Background(), 800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release.Done(TokenCount{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, 800); err != context.DeadlineExceeded {
+		t.Errorf("expected estimate kept for unknown usage; got %v", err)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if n := EstimateTokens(Text("hello world!"), Text("你好")); n != 5 {
+		t.Errorf("expected 5; got %d", n)
+	}
+	history := []Content{{Role: RoleUser, Parts: []Part{Text("hello world!")}}, {Role: RoleAssistant, Parts: []Part{Text("你好")}}}
+	if n := EstimateHistory(history); n != 5 {
+		t.Errorf("expected 5; got %d", n)
+	}
+}
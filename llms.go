@@ -1,18 +1,66 @@
 package ai
 
 import (
+	"context"
 	"encoding"
 	"errors"
 	"strings"
+	"sync"
 )
 
 const (
 	ChatGPT   LLMs = "ChatGPT"
 	Gemini    LLMs = "Gemini"
 	Anthropic LLMs = "Anthropic"
+	Ollama    LLMs = "Ollama"
+	// OpenAICompatible is a self-hosted server speaking the OpenAI chat completions API,
+	// such as vLLM, llama.cpp server, LM Studio or the /v1 API of Ollama.
+	OpenAICompatible LLMs = "OpenAICompatible"
 )
 
-var llms = []LLMs{ChatGPT, Gemini, Anthropic}
+// Factory creates an AI client from client options.
+type Factory func(context.Context, ...ClientOption) (AI, error)
+
+var (
+	mu        sync.RWMutex
+	llms      = []LLMs{ChatGPT, Gemini, Anthropic, Ollama, OpenAICompatible}
+	factories = make(map[LLMs]Factory)
+)
+
+// Register makes an AI provider available by the provided name.
+// If Register is called twice with the same name or if factory is nil, it panics.
+func Register(name LLMs, factory Factory) {
+	if name == "" {
+		panic("ai: Register name is empty")
+	}
+	if factory == nil {
+		panic("ai: Register factory is nil")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := factories[name]; dup {
+		panic("ai: Register called twice for " + string(name))
+	}
+	factories[name] = factory
+	for _, i := range llms {
+		if i == name {
+			return
+		}
+	}
+	llms = append(llms, name)
+}
+
+// Lookup returns the factory registered for the provided name.
+func Lookup(name LLMs) (Factory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for k, v := range factories {
+		if strings.EqualFold(string(k), string(name)) {
+			return v, true
+		}
+	}
+	return nil, false
+}
 
 var (
 	_ encoding.TextMarshaler   = LLMs("")
@@ -29,6 +77,8 @@ func (m *LLMs) UnmarshalText(text []byte) error {
 	if string(text) == "" {
 		return errors.New("empty LLMs name")
 	}
+	mu.RLock()
+	defer mu.RUnlock()
 	for _, i := range llms {
 		if strings.EqualFold(string(i), string(text)) {
 			*m = i
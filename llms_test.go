@@ -1,6 +1,7 @@
 package ai
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 )
@@ -19,3 +20,26 @@ func TestLLMs(t *testing.T) {
 		t.Error("expected error; got nil")
 	}
 }
+
+func TestRegister(t *testing.T) {
+	const custom LLMs = "Custom"
+	var llms LLMs
+	if err := json.Unmarshal([]byte(`"custom"`), &llms); err == nil {
+		t.Error("expected error; got nil")
+	}
+	Register(custom, func(context.Context, ...ClientOption) (AI, error) { return nil, nil })
+	if err := json.Unmarshal([]byte(`"custom"`), &llms); err != nil {
+		t.Error(err)
+	} else if llms != custom {
+		t.Errorf("expected %s; got %s", custom, llms)
+	}
+	if _, ok := Lookup(custom); !ok {
+		t.Errorf("expected %s registered", custom)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate Register")
+		}
+	}()
+	Register(custom, func(context.Context, ...ClientOption) (AI, error) { return nil, nil })
+}
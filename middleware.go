@@ -0,0 +1,107 @@
+package ai
+
+import "context"
+
+// Request is a chat call passing through middleware.
+type Request struct {
+	Parts []Part
+	// Stream is set for ChatStream calls.
+	Stream bool
+	// Session is the underlying session for session calls, nil otherwise.
+	Session ChatSession
+}
+
+// Result is the result of a chat call: Response for Chat calls and Stream for ChatStream calls.
+type Result struct {
+	Response ChatResponse
+	Stream   ChatStream
+}
+
+type ChatFunc func(ctx context.Context, req *Request) (*Result, error)
+
+// Middleware intercepts the Chat and ChatStream calls of an AI and its sessions.
+type Middleware func(next ChatFunc) ChatFunc
+
+// Chain combines middleware so that the first one is the outermost.
+func Chain(mw ...Middleware) Middleware {
+	return func(next ChatFunc) ChatFunc {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
+
+// Wrap returns an AI whose chat calls, including those of its sessions, pass through mw.
+// Other methods are passed through to ai.
+func Wrap(ai AI, mw ...Middleware) AI {
+	if len(mw) == 0 {
+		return ai
+	}
+	return withEmbedder(&wrappedAI{ai, Chain(mw...)}, ai)
+}
+
+type wrappedAI struct {
+	AI
+	mw Middleware
+}
+
+func call(c Chatbot) ChatFunc {
+	return func(ctx context.Context, req *Request) (*Result, error) {
+		if req.Stream {
+			stream, err := c.ChatStream(ctx, req.Parts...)
+			if err != nil {
+				return nil, err
+			}
+			return &Result{Stream: stream}, nil
+		}
+		resp, err := c.Chat(ctx, req.Parts...)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Response: resp}, nil
+	}
+}
+
+func (ai *wrappedAI) Chat(ctx context.Context, parts ...Part) (ChatResponse, error) {
+	res, err := ai.mw(call(ai.AI))(ctx, &Request{Parts: parts})
+	if err != nil {
+		return nil, err
+	}
+	return res.Response, nil
+}
+
+func (ai *wrappedAI) ChatStream(ctx context.Context, parts ...Part) (ChatStream, error) {
+	res, err := ai.mw(call(ai.AI))(ctx, &Request{Parts: parts, Stream: true})
+	if err != nil {
+		return nil, err
+	}
+	return res.Stream, nil
+}
+
+func (ai *wrappedAI) ChatSession() ChatSession {
+	return &wrappedSession{ai.AI.ChatSession(), ai.mw}
+}
+
+type wrappedSession struct {
+	ChatSession
+	mw Middleware
+}
+
+func (s *wrappedSession) SnapshotHistory() func() { return restoreHistory(s.ChatSession) }
+
+func (s *wrappedSession) Chat(ctx context.Context, parts ...Part) (ChatResponse, error) {
+	res, err := s.mw(call(s.ChatSession))(ctx, &Request{Parts: parts, Session: s.ChatSession})
+	if err != nil {
+		return nil, err
+	}
+	return res.Response, nil
+}
+
+func (s *wrappedSession) ChatStream(ctx context.Context, parts ...Part) (ChatStream, error) {
+	res, err := s.mw(call(s.ChatSession))(ctx, &Request{Parts: parts, Stream: true, Session: s.ChatSession})
+	if err != nil {
+		return nil, err
+	}
+	return res.Stream, nil
+}
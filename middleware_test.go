@@ -0,0 +1,48 @@
+package ai
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type testAI struct {
+	AI
+	session *testSession
+}
+
+func (ai *testAI) Chat(_ context.Context, parts ...Part) (ChatResponse, error) {
+	return testResponse{results: []string{string(parts[0].(Text))}}, nil
+}
+func (ai *testAI) ChatSession() ChatSession { return ai.session }
+
+func TestWrap(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next ChatFunc) ChatFunc {
+			return func(ctx context.Context, req *Request) (*Result, error) {
+				calls = append(calls, name)
+				req.Parts = append([]Part{Text(name)}, req.Parts...)
+				return next(ctx, req)
+			}
+		}
+	}
+	session := &testSession{replies: []testResponse{{results: []string{"ok"}}}}
+	c := Wrap(&testAI{session: session}, mw("a"), mw("b"))
+	resp, err := c.Chat(context.Background(), Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"b"}) {
+		t.Errorf("expected [b]; got %q", res)
+	}
+	if expect := []string{"a", "b"}; !reflect.DeepEqual(calls, expect) {
+		t.Errorf("expected %q; got %q", expect, calls)
+	}
+	if _, err := c.ChatSession().Chat(context.Background(), Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []Part{Text("b"), Text("a"), Text("hello")}; !reflect.DeepEqual(session.sent[0], expect) {
+		t.Errorf("expected %v; got %v", expect, session.sent[0])
+	}
+}
@@ -0,0 +1,664 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/sunshineplan/ai"
+)
+
+const (
+	defaultEndpoint = "http://localhost:11434"
+	defaultModel    = "llama3.2"
+)
+
+var _ ai.AI = new(Ollama)
+
+func init() {
+	ai.Register(ai.Ollama, func(_ context.Context, opts ...ai.ClientOption) (ai.AI, error) {
+		return New(opts...)
+	})
+	ai.RegisterErrorClassifier(func(err error) (info ai.ErrorInfo, ok bool) {
+		var e *Error
+		if ok = errors.As(err, &e); ok {
+			info.StatusCode = e.StatusCode
+			if e.Header != nil {
+				info.RetryAfter = ai.RetryAfter(e.Header)
+			}
+		}
+		return
+	})
+}
+
+// Error is an error returned by the Ollama API. StatusCode is zero for errors reported within a stream.
+type Error struct {
+	StatusCode int
+	Header     http.Header
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return "ollama: " + e.Message
+	}
+	return fmt.Sprintf("ollama: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+type Ollama struct {
+	client   *http.Client
+	endpoint string
+	apiKey   string
+
+	model    string
+	system   string
+	tools    []Tool
+	noTools  bool
+	format   json.RawMessage
+	options  Options
+	thinking any
+
+	systemErr error
+	toolsErr  error
+	schemaErr error
+
+	ai.Limits
+}
+
+func New(opts ...ai.ClientOption) (ai.AI, error) {
+	cfg := new(ai.ClientConfig)
+	for _, i := range opts {
+		i.Apply(cfg)
+	}
+	client, err := ai.NewHTTPClient(*cfg)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := NewWithClient(client, cfg.Endpoint, cfg.Model).(*Ollama)
+	c.apiKey = cfg.APIKey
+	ai.ApplyLimits(c, *cfg)
+	ai.ApplyModelConfig(c, cfg.ModelConfig)
+	if cfg.Retry != nil {
+		return ai.Retry(c, *cfg.Retry), nil
+	}
+	return c, nil
+}
+
+func NewWithClient(client *http.Client, endpoint, model string) ai.AI {
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	if model == "" {
+		model = defaultModel
+	}
+	return &Ollama{client: client, endpoint: strings.TrimSuffix(endpoint, "/"), model: model}
+}
+
+func (*Ollama) LLMs() ai.LLMs {
+	return ai.Ollama
+}
+
+func (ollama *Ollama) Model() string {
+	return ollama.model
+}
+
+// wait acquires the limits for a request, estimating the tokens of the system instruction,
+// the history and the parts.
+func (ollama *Ollama) wait(ctx context.Context, history []Message, parts ...ai.Part) (ai.Release, error) {
+	if err := errors.Join(ollama.systemErr, ollama.toolsErr, ollama.schemaErr); err != nil {
+		return nil, err
+	}
+	tokens := ai.EstimateTokens(parts...) + ai.EstimateTokens(ai.Text(ollama.system)) +
+		ai.EstimateHistory((&ChatSession{ai: ollama, history: history}).History())
+	return ollama.Acquire(ctx, tokens)
+}
+
+func (ai *Ollama) SetModel(model string) { ai.model = model }
+func (ollama *Ollama) SetSystemInstruction(parts ...ai.Part) {
+	ollama.systemErr = nil
+	var system []string
+	for _, i := range parts {
+		if v, ok := i.(ai.Text); ok {
+			system = append(system, string(v))
+		} else {
+			ollama.systemErr = fmt.Errorf("ollama: unsupported system instruction part %T", i)
+		}
+	}
+	ollama.system = strings.Join(system, "\n")
+}
+
+func (ollama *Ollama) SetFunctionCall(f []ai.Function, mode ai.FunctionCallingMode) {
+	ollama.tools, ollama.toolsErr = nil, nil
+	// Ollama has no tool choice, so tools are left out to disable function calling,
+	// and function calls cannot be required.
+	ollama.noTools = mode == ai.FunctionCallingNone
+	if len(f) > 0 && mode == ai.FunctionCallingAny {
+		ollama.toolsErr = errors.New("ollama: function calling mode any is not supported")
+	}
+	for _, i := range f {
+		parameters, err := json.Marshal(i.Parameters)
+		if err != nil {
+			ollama.toolsErr = errors.Join(ollama.toolsErr, fmt.Errorf("ollama: function %q: %w", i.Name, err))
+			continue
+		}
+		ollama.tools = append(ollama.tools, Tool{
+			Type:     "function",
+			Function: ToolFunction{Name: i.Name, Description: i.Description, Parameters: parameters},
+		})
+	}
+}
+func (ai *Ollama) SetCount(i int64) {
+	fmt.Println("Ollama doesn't support SetCount")
+}
+func (ai *Ollama) SetMaxTokens(i int64)     { ai.options.NumPredict = &i }
+func (ai *Ollama) SetTemperature(f float64) { ai.options.Temperature = &f }
+func (ai *Ollama) SetTopP(f float64)        { ai.options.TopP = &f }
+func (ai *Ollama) SetJSONResponse(set bool, schema *ai.JSONSchema) {
+	ai.format, ai.schemaErr = nil, nil
+	if !set {
+		return
+	}
+	if schema == nil {
+		ai.format = json.RawMessage(`"json"`)
+		return
+	}
+	if ai.format, ai.schemaErr = json.Marshal(schema.Schema); ai.schemaErr != nil {
+		ai.schemaErr = fmt.Errorf("ollama: JSON schema %q: %w", schema.Name, ai.schemaErr)
+	}
+}
+func (ai *Ollama) SetThinking(set bool) {
+	// Off is sent explicitly, as some models think unless told not to.
+	ai.thinking = set
+}
+func (ollama *Ollama) SetThinkingConfig(cfg ai.ThinkingConfig) {
+	// Only some models take a level, so a level is sent only if the effort is set explicitly.
+	switch cfg.Effort {
+	case ai.ThinkingLow:
+		ollama.thinking = "low"
+	case ai.ThinkingMedium:
+		ollama.thinking = "medium"
+	case ai.ThinkingHigh:
+		ollama.thinking = "high"
+	default:
+		ollama.thinking = true
+	}
+}
+
+func (ollama *Ollama) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	if ollama.client == nil {
+		return nil, ai.ErrAIClosed
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ollama.endpoint+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ollama.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+ollama.apiKey)
+	}
+	resp, err := ollama.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		e := &Error{StatusCode: resp.StatusCode, Header: resp.Header, Message: strings.TrimSpace(string(b))}
+		var v struct{ Error string }
+		if json.Unmarshal(b, &v) == nil && v.Error != "" {
+			e.Message = v.Error
+		}
+		return nil, e
+	}
+	return resp, nil
+}
+
+func (ai *Ollama) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := ai.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tags struct {
+		Models []struct{ Name string }
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, i := range tags.Models {
+		res = append(res, i.Name)
+	}
+	return res, nil
+}
+
+// Message is a chat message of the Ollama API.
+type Message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
+	Images    [][]byte   `json:"images,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type Options struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  *int64   `json:"num_predict,omitempty"`
+}
+
+type chatRequest struct {
+	Model    string          `json:"model"`
+	Messages []Message       `json:"messages"`
+	Tools    []Tool          `json:"tools,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"`
+	Options  *Options        `json:"options,omitempty"`
+	Think    any             `json:"think,omitempty"`
+	Stream   bool            `json:"stream"`
+}
+
+// Response is a response, or a chunk of a streamed response, of the Ollama chat API.
+type Response struct {
+	Model           string  `json:"model"`
+	CreatedAt       string  `json:"created_at"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason,omitempty"`
+	PromptEvalCount int64   `json:"prompt_eval_count,omitempty"`
+	EvalCount       int64   `json:"eval_count,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+var _ ai.ChatResponse = new(ChatResponse)
+
+type ChatResponse struct {
+	resp *Response
+}
+
+func (resp *ChatResponse) Raw() any {
+	return resp.resp
+}
+
+func (resp *ChatResponse) Results() (res []string) {
+	if resp.resp.Message.Content != "" {
+		res = append(res, resp.resp.Message.Content)
+	}
+	return
+}
+
+func (resp *ChatResponse) Thoughts() (res []string) {
+	if resp.resp.Message.Thinking != "" {
+		res = append(res, resp.resp.Message.Thinking)
+	}
+	return
+}
+
+func (resp *ChatResponse) FunctionCalls() (res []ai.FunctionCall) {
+	for _, i := range resp.resp.Message.ToolCalls {
+		res = append(res, fromToolCall(i))
+	}
+	return
+}
+
+func (resp *ChatResponse) TokenCount() (res ai.TokenCount) {
+	res.Prompt = resp.resp.PromptEvalCount
+	res.Result = resp.resp.EvalCount
+	res.Total = res.Prompt + res.Result
+	return
+}
+
+func (resp *ChatResponse) String() string {
+	if res := resp.Results(); len(res) > 0 {
+		return res[0]
+	}
+	if res := resp.FunctionCalls(); len(res) > 0 {
+		var args []string
+		for _, i := range res {
+			args = append(args, i.Arguments)
+		}
+		return strings.Join(args, "\n")
+	}
+	return ""
+}
+
+// fromToolCall converts a tool call, using the function name as ID if it has none, as Ollama identifies
+// tool results by function name.
+func fromToolCall(call ToolCall) ai.FunctionCall {
+	id := call.ID
+	if id == "" {
+		id = call.Function.Name
+	}
+	return ai.FunctionCall{ID: id, Name: call.Function.Name, Arguments: string(call.Function.Arguments)}
+}
+
+func toToolCall(call ai.FunctionCall) (res ToolCall, err error) {
+	args := json.RawMessage(call.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	} else if !json.Valid(args) {
+		return res, fmt.Errorf("ollama: invalid arguments of function call %q", call.Name)
+	}
+	if call.ID != call.Name {
+		res.ID = call.ID
+	}
+	res.Function.Name, res.Function.Arguments = call.Name, args
+	return
+}
+
+// toMessages converts the parts of a user turn, with function responses sent as tool messages first.
+func toMessages(parts ...ai.Part) (msgs []Message, err error) {
+	user := Message{Role: ai.RoleUser}
+	var texts []string
+	for _, i := range parts {
+		switch v := i.(type) {
+		case ai.Text:
+			texts = append(texts, string(v))
+		case ai.Image:
+			_, data := v.Data()
+			user.Images = append(user.Images, data)
+		case ai.Blob:
+			user.Images = append(user.Images, v.Data)
+		case ai.FunctionResponse:
+			name := v.Name
+			if name == "" {
+				name = v.ID
+			}
+			msgs = append(msgs, Message{Role: ai.RoleTool, Content: v.Response, ToolName: name})
+		default:
+			return nil, fmt.Errorf("ollama: unsupported part type %T", i)
+		}
+	}
+	if user.Content = strings.Join(texts, "\n"); user.Content != "" || len(user.Images) > 0 {
+		msgs = append(msgs, user)
+	}
+	return
+}
+
+func (c *Ollama) createRequest(stream bool, history []Message, messages []Message) *chatRequest {
+	req := &chatRequest{Model: c.model, Format: c.format, Think: c.thinking, Stream: stream}
+	if !c.noTools {
+		req.Tools = c.tools
+	}
+	if c.options != (Options{}) {
+		options := c.options
+		req.Options = &options
+	}
+	if c.system != "" {
+		req.Messages = append(req.Messages, Message{Role: "system", Content: c.system})
+	}
+	req.Messages = append(append(req.Messages, history...), messages...)
+	return req
+}
+
+func (ollama *Ollama) chat(ctx context.Context, history []Message, messages []Message, parts ...ai.Part) (*Response, error) {
+	release, err := ollama.wait(ctx, history, parts...)
+	if err != nil {
+		return nil, err
+	}
+	var usage ai.TokenCount
+	defer func() { release.Done(usage) }()
+	resp, err := ollama.do(ctx, http.MethodPost, "/api/chat", ollama.createRequest(false, history, messages))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res := new(Response)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, &Error{Message: res.Error}
+	}
+	usage = (&ChatResponse{res}).TokenCount()
+	return res, nil
+}
+
+func (ai *Ollama) Chat(ctx context.Context, parts ...ai.Part) (ai.ChatResponse, error) {
+	messages, err := toMessages(parts...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ai.chat(ctx, nil, messages, parts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResponse{resp}, nil
+}
+
+var _ ai.ChatStream = new(ChatStream)
+
+// ChatStream reads a response streamed as newline-delimited JSON.
+type ChatStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	done    bool
+	session *ChatSession
+	message Message
+}
+
+func newChatStream(body io.ReadCloser, session *ChatSession) *ChatStream {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 16*1024*1024)
+	return &ChatStream{body: body, scanner: scanner, session: session, message: Message{Role: ai.RoleAssistant}}
+}
+
+func (cs *ChatStream) Next() (ai.ChatResponse, error) {
+	for !cs.done && cs.scanner.Scan() {
+		line := bytes.TrimSpace(cs.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		resp := new(Response)
+		if err := json.Unmarshal(line, resp); err != nil {
+			return nil, err
+		}
+		if resp.Error != "" {
+			return nil, &Error{Message: resp.Error}
+		}
+		cs.message.Content += resp.Message.Content
+		cs.message.Thinking += resp.Message.Thinking
+		cs.message.ToolCalls = append(cs.message.ToolCalls, resp.Message.ToolCalls...)
+		if resp.Done {
+			cs.done = true
+			if cs.session != nil {
+				// Thinking is not sent back to the model.
+				cs.message.Thinking = ""
+				cs.session.history = append(cs.session.history, cs.message)
+			}
+		}
+		return &ChatResponse{resp}, nil
+	}
+	if err := cs.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !cs.done {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return nil, io.EOF
+}
+
+func (cs *ChatStream) Close() error {
+	return cs.body.Close()
+}
+
+func (ollama *Ollama) chatStream(ctx context.Context, history []Message, messages []Message, parts ...ai.Part) (io.ReadCloser, ai.Release, error) {
+	release, err := ollama.wait(ctx, history, parts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ollama.do(ctx, http.MethodPost, "/api/chat", ollama.createRequest(true, history, messages))
+	if err != nil {
+		release.Done(ai.TokenCount{})
+		return nil, nil, err
+	}
+	return resp.Body, release, nil
+}
+
+func (ai *Ollama) ChatStream(ctx context.Context, parts ...ai.Part) (ai.ChatStream, error) {
+	messages, err := toMessages(parts...)
+	if err != nil {
+		return nil, err
+	}
+	body, release, err := ai.chatStream(ctx, nil, messages, parts...)
+	if err != nil {
+		return nil, err
+	}
+	return release.Stream(newChatStream(body, nil)), nil
+}
+
+var _ ai.ChatSession = new(ChatSession)
+
+type ChatSession struct {
+	ai      *Ollama
+	history []Message
+}
+
+func (session *ChatSession) Chat(ctx context.Context, parts ...ai.Part) (ai.ChatResponse, error) {
+	messages, err := toMessages(parts...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := session.ai.chat(ctx, session.history, messages, parts...)
+	if err != nil {
+		return nil, err
+	}
+	message := resp.Message
+	message.Thinking = ""
+	session.history = append(append(session.history, messages...), message)
+	return &ChatResponse{resp}, nil
+}
+
+func (session *ChatSession) ChatStream(ctx context.Context, parts ...ai.Part) (ai.ChatStream, error) {
+	messages, err := toMessages(parts...)
+	if err != nil {
+		return nil, err
+	}
+	body, release, err := session.ai.chatStream(ctx, session.history, messages, parts...)
+	if err != nil {
+		return nil, err
+	}
+	session.history = append(session.history, messages...)
+	return release.Stream(newChatStream(body, session)), nil
+}
+
+func (session *ChatSession) History() (history []ai.Content) {
+	for _, i := range session.history {
+		var parts []ai.Part
+		switch i.Role {
+		case ai.RoleTool:
+			parts = append(parts, ai.FunctionResponse{ID: i.ToolName, Name: i.ToolName, Response: i.Content})
+		default:
+			if i.Content != "" {
+				parts = append(parts, ai.Text(i.Content))
+			}
+			for _, img := range i.Images {
+				parts = append(parts, ai.Blob{MIMEType: http.DetectContentType(img), Data: img})
+			}
+			for _, call := range i.ToolCalls {
+				parts = append(parts, fromToolCall(call))
+			}
+		}
+		if len(parts) > 0 {
+			history = append(history, ai.Content{Role: i.Role, Parts: parts})
+		}
+	}
+	return
+}
+
+func (session *ChatSession) SetHistory(history []ai.Content) error {
+	var msgs []Message
+	for _, content := range ai.NormalizeHistory(history) {
+		if content.Role != ai.RoleAssistant {
+			messages, err := toMessages(content.Parts...)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, messages...)
+			continue
+		}
+		msg := Message{Role: ai.RoleAssistant}
+		var texts []string
+		for _, i := range content.Parts {
+			switch v := i.(type) {
+			case ai.Text:
+				texts = append(texts, string(v))
+			case ai.FunctionCall:
+				call, err := toToolCall(v)
+				if err != nil {
+					return err
+				}
+				msg.ToolCalls = append(msg.ToolCalls, call)
+			case ai.Thought:
+				// Thinking is not sent back to the model.
+			default:
+				return fmt.Errorf("ollama: unsupported assistant part %T", i)
+			}
+		}
+		msg.Content = strings.Join(texts, "\n")
+		msgs = append(msgs, msg)
+	}
+	session.history = msgs
+	return nil
+}
+
+func (session *ChatSession) SnapshotHistory() func() {
+	history := slices.Clone(session.history)
+	return func() { session.history = history }
+}
+
+// CountTokens estimates the tokens of parts, as Ollama has no API for it.
+func (ollama *Ollama) CountTokens(_ context.Context, parts ...ai.Part) (int64, error) {
+	if ollama.client == nil {
+		return 0, ai.ErrAIClosed
+	}
+	return ai.EstimateTokens(parts...), nil
+}
+
+func (ai *Ollama) ChatSession() ai.ChatSession {
+	return &ChatSession{ai: ai}
+}
+
+func (ai *Ollama) Close() error {
+	ai.client = nil
+	return nil
+}
@@ -0,0 +1,178 @@
+package ollama
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sunshineplan/ai"
+)
+
+func newServer(t *testing.T, requests *[]chatRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			io.WriteString(w, `{"models":[{"name":"llama3.2:latest"},{"name":"qwen3:8b"}]}`)
+		case "/api/chat":
+			var req chatRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Error(err)
+			}
+			*requests = append(*requests, req)
+			if req.Model == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `{"error":"model \"missing\" not found, try pulling it first"}`)
+				return
+			}
+			if !req.Stream {
+				io.WriteString(w, `{"model":"llama3.2","message":{"role":"assistant","content":"Hello","thinking":"greet"},"done":true,"prompt_eval_count":3,"eval_count":2}`)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			for _, i := range []string{
+				`{"model":"llama3.2","message":{"role":"assistant","content":"","thinking":"weather"},"done":false}`,
+				`{"model":"llama3.2","message":{"role":"assistant","content":"Let me "},"done":false}`,
+				`{"model":"llama3.2","message":{"role":"assistant","content":"check."},"done":false}`,
+				`{"model":"llama3.2","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"weather","arguments":{"city":"Paris"}}}]},"done":false}`,
+				`{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":10,"eval_count":5}`,
+			} {
+				io.WriteString(w, i+"\n")
+				w.(http.Flusher).Flush()
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestOllama(t *testing.T) {
+	var requests []chatRequest
+	ts := newServer(t, &requests)
+	defer ts.Close()
+	c, err := New(ai.WithEndpoint(ts.URL), ai.WithModel("llama3.2"), ai.WithLimit(60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	models, err := c.ListModels(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"llama3.2:latest", "qwen3:8b"}; !reflect.DeepEqual(models, expect) {
+		t.Errorf("expected %q; got %q", expect, models)
+	}
+
+	c.SetSystemInstruction(ai.Text("Be brief."))
+	c.SetTemperature(0)
+	c.SetThinking(true)
+	c.SetJSONResponse(true, &ai.JSONSchema{Name: "test", Schema: ai.Schema{Type: "object"}})
+	resp, err := c.Chat(t.Context(), ai.Text("Hi"), ai.ImageData("image/png", []byte("png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := resp.Results(); !reflect.DeepEqual(res, []string{"Hello"}) {
+		t.Errorf("expected [Hello]; got %q", res)
+	}
+	if res := resp.Thoughts(); !reflect.DeepEqual(res, []string{"greet"}) {
+		t.Errorf("expected [greet]; got %q", res)
+	}
+	if tokens := resp.TokenCount(); tokens != (ai.TokenCount{Prompt: 3, Result: 2, Total: 5}) {
+		t.Errorf("unexpected token count %v", tokens)
+	}
+	req := requests[0]
+	if n := len(req.Messages); n != 2 || req.Messages[0].Role != "system" || string(req.Messages[1].Images[0]) != "png" {
+		t.Errorf("unexpected messages %+v", req.Messages)
+	}
+	if req.Think != true || string(req.Format) != `{"type":"object"}` || *req.Options.Temperature != 0 {
+		t.Errorf("unexpected request %+v", req)
+	}
+	c.SetJSONResponse(false, nil)
+
+	c.SetFunctionCall([]ai.Function{{Name: "weather", Parameters: ai.Schema{Type: "object"}}}, ai.FunctionCallingAuto)
+	session := c.ChatSession()
+	stream, err := session.ChatStream(t.Context(), ai.Text("Weather in Paris?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var results, thoughts []string
+	var calls []ai.FunctionCall
+	var tokens ai.TokenCount
+	for {
+		resp, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, resp.Results()...)
+		thoughts = append(thoughts, resp.Thoughts()...)
+		calls = append(calls, resp.FunctionCalls()...)
+		if resp.TokenCount().Total > 0 {
+			tokens = resp.TokenCount()
+		}
+	}
+	if strings.Join(results, "") != "Let me check." || !reflect.DeepEqual(thoughts, []string{"weather"}) || tokens.Total != 15 {
+		t.Errorf("unexpected stream %q %q %v", results, thoughts, tokens)
+	}
+	call := ai.FunctionCall{ID: "weather", Name: "weather", Arguments: `{"city":"Paris"}`}
+	if !reflect.DeepEqual(calls, []ai.FunctionCall{call}) {
+		t.Errorf("expected %v; got %v", call, calls)
+	}
+	if n := len(requests[1].Tools); n != 1 {
+		t.Errorf("expected 1 tool; got %d", n)
+	}
+
+	if _, err := session.Chat(t.Context(), ai.FunctionResponse{ID: call.ID, Name: call.Name, Response: `{"temp":20}`}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := requests[2].Messages
+	if n := len(msgs); n != 4 {
+		t.Fatalf("expected 4 messages; got %d", n)
+	}
+	if msgs[2].Content != "Let me check." || len(msgs[2].ToolCalls) != 1 || msgs[3].Role != "tool" || msgs[3].ToolName != "weather" {
+		t.Errorf("unexpected messages %+v", msgs)
+	}
+	history := session.History()
+	if n := len(history); n != 4 {
+		t.Fatalf("expected 4 contents; got %d", n)
+	}
+	restored := c.ChatSession()
+	if err := restored.SetHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.History(), history) {
+		t.Errorf("expected %v; got %v", history, restored.History())
+	}
+
+	c.SetModel("missing")
+	_, err = c.Chat(t.Context(), ai.Text("Hi"))
+	if info, ok := ai.ClassifyError(err); !ok || info.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 error; got %v", err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	c := NewWithClient(http.DefaultClient, "", "").(*Ollama)
+	c.SetThinking(false)
+	b, err := json.Marshal(c.createRequest(false, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"think":false`) {
+		t.Errorf("expected think false; got %s", b)
+	}
+	c.SetFunctionCall([]ai.Function{{Name: "weather"}}, ai.FunctionCallingAny)
+	if _, err := c.wait(t.Context(), nil); err == nil {
+		t.Error("expected error for function calling mode any; got nil")
+	}
+	c.SetFunctionCall([]ai.Function{{Name: "weather"}}, ai.FunctionCallingAuto)
+	if _, err := c.wait(t.Context(), nil); err != nil {
+		t.Errorf("expected no error for function calling mode auto; got %v", err)
+	}
+}
@@ -0,0 +1,514 @@
+package ai
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrPoolExhausted = errors.New("no AI available in pool")
+
+var _ encoding.TextUnmarshaler = new(Balance)
+
+// Balance is the strategy a pool uses to spread requests over its members.
+type Balance int
+
+func (b *Balance) UnmarshalText(text []byte) error {
+	switch strings.ReplaceAll(strings.ToLower(string(text)), "_", "-") {
+	case "round-robin":
+		*b = RoundRobin
+	case "least-loaded":
+		*b = LeastLoaded
+	case "weighted":
+		*b = Weighted
+	default:
+		*b = 0
+	}
+	return nil
+}
+
+const (
+	// RoundRobin sends requests to the members in turn.
+	RoundRobin Balance = iota + 1
+	// LeastLoaded sends requests to the member with the fewest requests in flight.
+	LeastLoaded
+	// Weighted sends requests to members at random, weighted by their remaining requests per minute.
+	Weighted
+)
+
+// Pool returns an AI which spreads requests over members, typically clients of the same provider
+// using different API keys or endpoints. Members returning authentication or quota errors are taken
+// out of rotation, and the request is sent to another member. Members which are rate limited, or have
+// used up their requests per minute, are skipped until they can take requests again, and a request
+// which is rate limited is sent to another member. Responses are *ServedResponse values.
+//
+// Limits are the sums of the limits of the members in rotation, and setting a limit spreads it over them.
+// Model settings are applied to all members. If all members implement Embedder, so does the pool,
+// spreading embedding requests over them like chat requests.
+func Pool(balance Balance, members ...AI) AI {
+	if len(members) == 0 {
+		panic("ai: Pool requires at least one member")
+	}
+	p := &pool{balance: balance}
+	embedder := true
+	for _, i := range members {
+		p.members = append(p.members, &poolMember{AI: i})
+		if _, ok := i.(Embedder); !ok {
+			embedder = false
+		}
+	}
+	if embedder {
+		return &embedderAI{p, poolEmbedder{p}}
+	}
+	return p
+}
+
+type pool struct {
+	mu      sync.Mutex
+	balance Balance
+	members []*poolMember
+	next    int
+}
+
+type poolMember struct {
+	AI
+	inflight int
+	requests []time.Time
+	disabled bool
+	// limited is the time until which the member is rate limited by the API.
+	limited time.Time
+}
+
+// remaining returns the number of requests the member can still make in the current minute.
+func (m *poolMember) remaining(now time.Time) int64 {
+	limit := m.Limit()
+	if limit == math.MaxInt64 {
+		return limit
+	}
+	i := 0
+	for i < len(m.requests) && now.Sub(m.requests[i]) >= time.Minute {
+		i++
+	}
+	m.requests = m.requests[i:]
+	return max(limit-int64(len(m.requests)), 0)
+}
+
+// available reports whether the member can take a request without waiting.
+func (m *poolMember) available(now time.Time) bool {
+	return !now.Before(m.limited) && m.remaining(now) > 0
+}
+
+func (m *poolMember) start(now time.Time) {
+	m.inflight++
+	if m.Limit() != math.MaxInt64 {
+		m.requests = append(m.requests, now)
+	}
+}
+
+func (p *pool) active() (members []*poolMember) {
+	for _, i := range p.members {
+		if !i.disabled {
+			members = append(members, i)
+		}
+	}
+	return
+}
+
+// acquire picks a member for a request, which must be released when done, leaving out
+// the members tried already. Members which can take the request without waiting are preferred.
+// It returns nil if all members in rotation have been tried.
+func (p *pool) acquire(tried []*poolMember) (*poolMember, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	members := p.active()
+	if len(members) == 0 {
+		return nil, ErrPoolExhausted
+	}
+	members = slices.DeleteFunc(members, func(m *poolMember) bool { return slices.Contains(tried, m) })
+	if len(members) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	if available := slices.DeleteFunc(slices.Clone(members), func(m *poolMember) bool { return !m.available(now) }); len(available) > 0 {
+		members = available
+	}
+	var m *poolMember
+	switch p.balance {
+	case LeastLoaded:
+		var remaining int64
+		for _, i := range members {
+			if r := i.remaining(now); m == nil || i.inflight < m.inflight || i.inflight == m.inflight && r > remaining {
+				m, remaining = i, r
+			}
+		}
+	case Weighted:
+		// Unlimited members take all requests if there are any.
+		unlimited := slices.ContainsFunc(members, func(m *poolMember) bool { return m.Limit() == math.MaxInt64 })
+		weights := make([]int64, len(members))
+		var total int64
+		for n, i := range members {
+			if !unlimited {
+				weights[n] = i.remaining(now)
+			} else if i.Limit() == math.MaxInt64 {
+				weights[n] = 1
+			}
+			total += weights[n]
+		}
+		if total == 0 {
+			m = members[rand.N(len(members))]
+			break
+		}
+		r := rand.N(total)
+		for n, w := range weights {
+			if r < w {
+				m = members[n]
+				break
+			}
+			r -= w
+		}
+	default:
+		for m == nil || !slices.Contains(members, m) {
+			m = p.members[p.next%len(p.members)]
+			p.next++
+		}
+	}
+	m.start(now)
+	return m, nil
+}
+
+// release marks the request done, taking the member out of rotation if err shows it is unusable,
+// or skipping it for a while if it is rate limited.
+// It reports whether the request should be sent to another member.
+func (p *pool) release(m *poolMember, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m.inflight--
+	if info, ok := ClassifyError(err); ok {
+		switch {
+		case info.StatusCode == http.StatusUnauthorized, info.StatusCode == http.StatusForbidden, info.QuotaExceeded:
+			m.disabled = true
+			return true
+		case info.StatusCode == http.StatusTooManyRequests:
+			wait := info.RetryAfter
+			if wait <= 0 {
+				wait = time.Minute
+			}
+			m.limited = time.Now().Add(wait)
+			return true
+		}
+	}
+	return false
+}
+
+// do calls fn with a member, sending the request to another member as release decides,
+// until every member in rotation has been tried.
+func (p *pool) do(fn func(m *poolMember) error) error {
+	var tried []*poolMember
+	var last error
+	for {
+		m, err := p.acquire(tried)
+		if err != nil {
+			return err
+		} else if m == nil {
+			return last
+		}
+		if err = fn(m); err == nil || !p.release(m, err) {
+			return err
+		}
+		tried, last = append(tried, m), err
+	}
+}
+
+func (p *pool) LLMs() LLMs            { return p.members[0].LLMs() }
+func (p *pool) Model() string         { return p.members[0].Model() }
+func (p *pool) SetModel(model string) { p.each(func(c AI) { c.SetModel(model) }) }
+
+func (p *pool) ListModels(ctx context.Context) (models []string, err error) {
+	err = p.do(func(m *poolMember) (err error) {
+		models, err = m.ListModels(ctx)
+		if err == nil {
+			p.release(m, nil)
+		}
+		return
+	})
+	return
+}
+
+func (p *pool) CountTokens(ctx context.Context, parts ...Part) (n int64, err error) {
+	err = p.do(func(m *poolMember) (err error) {
+		n, err = m.CountTokens(ctx, parts...)
+		if err == nil {
+			p.release(m, nil)
+		}
+		return
+	})
+	return
+}
+
+type poolEmbedder struct{ *pool }
+
+func (p poolEmbedder) SetEmbeddingConfig(cfg EmbeddingConfig) {
+	p.each(func(c AI) { c.(Embedder).SetEmbeddingConfig(cfg) })
+}
+
+func (p poolEmbedder) Embed(ctx context.Context, texts ...string) (vectors [][]float32, usage TokenCount, err error) {
+	err = p.do(func(m *poolMember) (err error) {
+		vectors, usage, err = m.AI.(Embedder).Embed(ctx, texts...)
+		if err == nil {
+			p.release(m, nil)
+		}
+		return
+	})
+	return
+}
+
+// spread spreads x over the members in rotation.
+func (p *pool) spread(x int64, set func(AI, int64)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	members := p.active()
+	if len(members) == 0 {
+		return
+	}
+	if x == math.MaxInt64 {
+		for _, i := range members {
+			set(i.AI, x)
+		}
+		return
+	}
+	for n, i := range members {
+		limit := x / int64(len(members))
+		if int64(n) < x%int64(len(members)) {
+			limit++
+		}
+		set(i.AI, max(limit, 1))
+	}
+}
+
+// sum sums the limits of the members in rotation.
+func (p *pool) sum(get func(AI) int64) (x int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, i := range p.active() {
+		limit := get(i.AI)
+		if limit == math.MaxInt64 {
+			return limit
+		}
+		x += limit
+	}
+	return
+}
+
+func (p *pool) SetLimit(rpm int64)      { p.spread(rpm, AI.SetLimit) }
+func (p *pool) Limit() (rpm int64)      { return p.sum(AI.Limit) }
+func (p *pool) SetTokenLimit(tpm int64) { p.spread(tpm, AI.SetTokenLimit) }
+func (p *pool) TokenLimit() (tpm int64) { return p.sum(AI.TokenLimit) }
+func (p *pool) SetConcurrency(n int64)  { p.spread(n, AI.SetConcurrency) }
+func (p *pool) Concurrency() (n int64)  { return p.sum(AI.Concurrency) }
+
+func (p *pool) each(fn func(AI)) {
+	for _, i := range p.members {
+		fn(i.AI)
+	}
+}
+
+func (p *pool) SetSystemInstruction(parts ...Part) {
+	p.each(func(c AI) { c.SetSystemInstruction(parts...) })
+}
+func (p *pool) SetFunctionCall(f []Function, mode FunctionCallingMode) {
+	p.each(func(c AI) { c.SetFunctionCall(f, mode) })
+}
+func (p *pool) SetCount(x int64)         { p.each(func(c AI) { c.SetCount(x) }) }
+func (p *pool) SetMaxTokens(x int64)     { p.each(func(c AI) { c.SetMaxTokens(x) }) }
+func (p *pool) SetTemperature(x float64) { p.each(func(c AI) { c.SetTemperature(x) }) }
+func (p *pool) SetTopP(x float64)        { p.each(func(c AI) { c.SetTopP(x) }) }
+func (p *pool) SetJSONResponse(set bool, schema *JSONSchema) {
+	p.each(func(c AI) { c.SetJSONResponse(set, schema) })
+}
+func (p *pool) SetThinking(set bool) { p.each(func(c AI) { c.SetThinking(set) }) }
+func (p *pool) SetThinkingConfig(cfg ThinkingConfig) {
+	p.each(func(c AI) { c.SetThinkingConfig(cfg) })
+}
+
+func (p *pool) Chat(ctx context.Context, parts ...Part) (resp ChatResponse, err error) {
+	err = p.do(func(m *poolMember) (err error) {
+		if resp, err = m.Chat(ctx, parts...); err == nil {
+			p.release(m, nil)
+			resp = &ServedResponse{resp, m.AI}
+		}
+		return
+	})
+	return
+}
+
+func (p *pool) ChatStream(ctx context.Context, parts ...Part) (stream ChatStream, err error) {
+	err = p.do(func(m *poolMember) (err error) {
+		if stream, err = m.ChatStream(ctx, parts...); err == nil {
+			stream = &poolStream{ChatStream: stream, pool: p, member: m}
+		}
+		return
+	})
+	return
+}
+
+// poolStream releases its member once the stream ends or is closed,
+// so that a stream which is read to the end but never closed does not stay in flight.
+type poolStream struct {
+	ChatStream
+	pool     *pool
+	member   *poolMember
+	released bool
+}
+
+func (s *poolStream) release(err error) {
+	if !s.released {
+		s.pool.release(s.member, err)
+		s.released = true
+	}
+}
+
+func (s *poolStream) Next() (ChatResponse, error) {
+	resp, err := s.ChatStream.Next()
+	if err == io.EOF {
+		s.release(nil)
+	} else if err != nil {
+		s.release(err)
+	}
+	if resp != nil {
+		resp = &ServedResponse{resp, s.member.AI}
+	}
+	return resp, err
+}
+
+func (s *poolStream) Close() error {
+	s.release(nil)
+	return s.ChatStream.Close()
+}
+
+func (p *pool) ChatSession() ChatSession {
+	return &poolSession{pool: p}
+}
+
+func (p *pool) Close() error {
+	var errs []error
+	for _, i := range p.members {
+		errs = append(errs, i.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// poolSession keeps its conversation on one member, migrating the history
+// to another member if it is taken out of rotation.
+type poolSession struct {
+	pool    *pool
+	member  *poolMember
+	session ChatSession
+	history []Content
+}
+
+func (s *poolSession) open(m *poolMember) (ChatSession, error) {
+	if m == s.member {
+		return s.session, nil
+	}
+	session := m.ChatSession()
+	if history := s.History(); len(history) > 0 {
+		if err := session.SetHistory(history); err != nil {
+			return nil, err
+		}
+	}
+	s.member, s.session, s.history = m, session, nil
+	return session, nil
+}
+
+// acquire returns the member of the session, picking a new one if there is none,
+// it is out of rotation or it has been tried already.
+func (s *poolSession) acquire(tried []*poolMember) (*poolMember, error) {
+	s.pool.mu.Lock()
+	now := time.Now()
+	if m := s.member; m != nil && !m.disabled && !now.Before(m.limited) && !slices.Contains(tried, m) {
+		m.start(now)
+		s.pool.mu.Unlock()
+		return m, nil
+	}
+	s.pool.mu.Unlock()
+	return s.pool.acquire(tried)
+}
+
+func (s *poolSession) do(fn func(m *poolMember, session ChatSession) error) error {
+	var tried []*poolMember
+	var last error
+	for {
+		m, err := s.acquire(tried)
+		if err != nil {
+			return err
+		} else if m == nil {
+			return last
+		}
+		session, err := s.open(m)
+		if err == nil {
+			if err = fn(m, session); err == nil {
+				return nil
+			}
+		}
+		if !s.pool.release(m, err) {
+			return err
+		}
+		tried, last = append(tried, m), err
+	}
+}
+
+func (s *poolSession) Chat(ctx context.Context, parts ...Part) (resp ChatResponse, err error) {
+	err = s.do(func(m *poolMember, session ChatSession) (err error) {
+		if resp, err = session.Chat(ctx, parts...); err == nil {
+			s.pool.release(m, nil)
+			resp = &ServedResponse{resp, m.AI}
+		}
+		return
+	})
+	return
+}
+
+func (s *poolSession) ChatStream(ctx context.Context, parts ...Part) (stream ChatStream, err error) {
+	err = s.do(func(m *poolMember, session ChatSession) (err error) {
+		if stream, err = session.ChatStream(ctx, parts...); err == nil {
+			stream = &poolStream{ChatStream: stream, pool: s.pool, member: m}
+		}
+		return
+	})
+	return
+}
+
+func (s *poolSession) History() []Content {
+	if s.session == nil {
+		return s.history
+	}
+	return s.session.History()
+}
+
+func (s *poolSession) SetHistory(history []Content) error {
+	if s.session == nil {
+		s.history = history
+		return nil
+	}
+	return s.session.SetHistory(history)
+}
+
+func (s *poolSession) SnapshotHistory() func() {
+	member, session, history := s.member, s.session, slices.Clone(s.history)
+	if session == nil {
+		return func() { s.member, s.session, s.history = member, session, history }
+	}
+	restore := restoreHistory(session)
+	return func() {
+		s.member, s.session, s.history = member, session, history
+		restore()
+	}
+}
@@ -0,0 +1,147 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type statusError int
+
+func (e statusError) Error() string { return fmt.Sprintf("status %d", int(e)) }
+
+func init() {
+	RegisterErrorClassifier(func(err error) (ErrorInfo, bool) {
+		if e, ok := err.(statusError); ok {
+			return ErrorInfo{StatusCode: int(e)}, true
+		}
+		return ErrorInfo{}, false
+	})
+}
+
+type poolBackend struct {
+	fallbackBackend
+	limit int64
+}
+
+func (b *poolBackend) Limit() int64 { return b.limit }
+
+func TestPool(t *testing.T) {
+	a, b, c := &poolBackend{limit: 10}, &poolBackend{limit: 20}, &poolBackend{limit: 30}
+	p := Pool(RoundRobin, a, b, c)
+	if limit := p.Limit(); limit != 60 {
+		t.Errorf("expected limit 60; got %d", limit)
+	}
+	for range 6 {
+		if _, err := p.Chat(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, i := range []*poolBackend{a, b, c} {
+		if i.calls != 2 {
+			t.Errorf("expected 2 calls; got %d", i.calls)
+		}
+	}
+
+	a.err = statusError(401)
+	for range 4 {
+		resp, err := p.Chat(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ServedBy(resp) == a {
+			t.Error("expected a out of rotation")
+		}
+	}
+	if a.calls != 3 {
+		t.Errorf("expected 3 calls; got %d", a.calls)
+	}
+	if limit := p.Limit(); limit != 50 {
+		t.Errorf("expected limit 50; got %d", limit)
+	}
+
+	b.err, c.err = statusError(403), statusError(500)
+	if _, err := p.Chat(context.Background()); err != statusError(500) {
+		t.Errorf("expected status 500; got %v", err)
+	}
+	c.err = statusError(403)
+	if _, err := p.Chat(context.Background()); err != ErrPoolExhausted {
+		t.Errorf("expected ErrPoolExhausted; got %v", err)
+	}
+}
+
+func TestPoolWeighted(t *testing.T) {
+	a, b := &poolBackend{limit: 1}, &poolBackend{limit: 100}
+	p := Pool(Weighted, a, b)
+	for range 50 {
+		if _, err := p.Chat(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.calls > 1 {
+		t.Errorf("expected at most 1 call; got %d", a.calls)
+	}
+}
+
+func TestPoolRateLimit(t *testing.T) {
+	a, b := &poolBackend{limit: 1}, &poolBackend{limit: 100}
+	p := Pool(RoundRobin, a, b)
+	for range 4 {
+		if _, err := p.Chat(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.calls != 1 || b.calls != 3 {
+		t.Errorf("expected 1 and 3 calls; got %d and %d", a.calls, b.calls)
+	}
+
+	a, b = &poolBackend{limit: 100, fallbackBackend: fallbackBackend{err: statusError(429)}}, &poolBackend{limit: 100}
+	p = Pool(RoundRobin, a, b)
+	for range 3 {
+		resp, err := p.Chat(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ServedBy(resp) != b {
+			t.Error("expected b to serve the request")
+		}
+	}
+	if a.calls != 1 {
+		t.Errorf("expected a skipped after 429; got %d calls", a.calls)
+	}
+	if limit := p.Limit(); limit != 200 {
+		t.Errorf("expected a kept in rotation; got limit %d", limit)
+	}
+	b.err = statusError(429)
+	if _, err := p.Chat(context.Background()); err != statusError(429) {
+		t.Errorf("expected status 429; got %v", err)
+	}
+}
+
+type poolStreamBackend struct{ poolBackend }
+
+func (*poolStreamBackend) ChatStream(context.Context, ...Part) (ChatStream, error) {
+	return &flakyStream{io.EOF}, nil
+}
+
+func TestPoolStream(t *testing.T) {
+	p := Pool(LeastLoaded, &poolStreamBackend{}).(*pool)
+	stream, err := p.ChatStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.members[0].inflight; n != 1 {
+		t.Errorf("expected 1 request in flight; got %d", n)
+	}
+	if _, err := stream.Next(); err != io.EOF {
+		t.Fatalf("expected EOF; got %v", err)
+	}
+	if n := p.members[0].inflight; n != 0 {
+		t.Errorf("expected no request in flight after EOF; got %d", n)
+	}
+	stream.Close()
+	if n := p.members[0].inflight; n != 0 {
+		t.Errorf("expected no request in flight after Close; got %d", n)
+	}
+}
@@ -2,6 +2,7 @@ package prompt
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
@@ -14,6 +15,9 @@ import (
 
 const defaultTimeout = 3 * time.Minute
 
+// ErrTokenBudget is returned when a single input does not fit in the token budget.
+var ErrTokenBudget = errors.New("input exceeds token budget")
+
 const defaultTemplate = `{{.Request}}{{if .Example}}
 ###
 Example:
@@ -46,6 +50,7 @@ type Prompt struct {
 	t      *template.Template
 	ex     *Example
 	n      int
+	tokens int64
 
 	d time.Duration
 }
@@ -71,38 +76,83 @@ func (prompt *Prompt) SetInputN(n int) *Prompt {
 	return prompt
 }
 
+// SetTokenBudget limits each prompt to about tokens, in addition to the number of inputs set by SetInputN.
+// Tokens are estimated offline with ai.EstimateTokens, so batching makes no API calls,
+// but the estimate may differ from the count of the provider and the budget should leave room for it.
+func (prompt *Prompt) SetTokenBudget(tokens int64) *Prompt {
+	prompt.tokens = tokens
+	return prompt
+}
+
 func (prompt *Prompt) SetAITimeout(d time.Duration) *Prompt {
 	prompt.d = d
 	return prompt
 }
 
-func (prompt *Prompt) Prompts(input []string, prefix string) (prompts []string, err error) {
-	length := len(input)
-	if length == 0 {
-		return
+func (prompt *Prompt) execute(input []string, prefix string, start int) (string, error) {
+	var b strings.Builder
+	if err := prompt.t.Execute(&b, struct {
+		Request string
+		Example *Example
+		Input   []string
+		Prefix  string
+		Start   int
+	}{prompt.prompt, prompt.ex, input, prefix, start}); err != nil {
+		return "", err
 	}
+	return b.String(), nil
+}
+
+// batches returns the ends of the input batches, estimating tokens if a token budget is set.
+// The tokens of a batch are taken as those of the prompt without input plus those of each input line.
+func (prompt *Prompt) batches(input []string, prefix string) (ends []int, err error) {
 	n := prompt.n
 	if n == 0 {
-		n = length
+		n = len(input)
 	}
-	for i := 0; i < length; i = i + n {
-		var s []string
-		if i+n < length {
-			s = input[i : i+n]
-		} else {
-			s = input[i:]
+	if prompt.tokens <= 0 {
+		for i := n; i < len(input); i += n {
+			ends = append(ends, i)
 		}
-		var b strings.Builder
-		if err = prompt.t.Execute(&b, struct {
-			Request string
-			Example *Example
-			Input   []string
-			Prefix  string
-			Start   int
-		}{prompt.prompt, prompt.ex, s, prefix, i}); err != nil {
+		return append(ends, len(input)), nil
+	}
+	s, err := prompt.execute(nil, prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	base := ai.EstimateTokens(ai.Text(s))
+	var start int
+	tokens := base
+	for i := range input {
+		t := ai.EstimateTokens(ai.Text(printBatch(input[i:i+1], prefix, i)))
+		if base+t > prompt.tokens {
+			return nil, fmt.Errorf("input %d: %w: %d > %d", i, ErrTokenBudget, base+t, prompt.tokens)
+		}
+		if i > start && (i-start == n || tokens+t > prompt.tokens) {
+			ends = append(ends, i)
+			start, tokens = i, base
+		}
+		tokens += t
+	}
+	return append(ends, len(input)), nil
+}
+
+func (prompt *Prompt) Prompts(input []string, prefix string) (prompts []string, err error) {
+	if len(input) == 0 {
+		return
+	}
+	ends, err := prompt.batches(input, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var start int
+	for _, end := range ends {
+		s, err := prompt.execute(input[start:end], prefix, start)
+		if err != nil {
 			return nil, err
 		}
-		prompts = append(prompts, b.String())
+		prompts = append(prompts, s)
+		start = end
 	}
 	return
 }
@@ -115,12 +165,16 @@ type Result struct {
 	Error  error
 }
 
+// limit returns the number of workers, which is the concurrency of ai if set,
+// falling back to its requests per minute otherwise.
 func limit(ai ai.AI) int {
-	var limit int
+	if n := ai.Concurrency(); n != math.MaxInt64 {
+		return int(n)
+	}
 	if rpm := ai.Limit(); rpm != math.MaxInt64 {
 		return int(rpm)
 	}
-	return limit
+	return 0
 }
 
 func (prompt *Prompt) Execute(ai ai.AI, input []string, prefix string) (<-chan *Result, int, error) {
@@ -1,8 +1,13 @@
 package prompt
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/sunshineplan/ai"
+	"github.com/sunshineplan/ai/aitest"
 )
 
 func TestPrompt(t *testing.T) {
@@ -69,3 +74,59 @@ func TestPrompt(t *testing.T) {
 		}
 	}
 }
+
+func TestExecute(t *testing.T) {
+	fake := aitest.New(
+		aitest.Reply{Results: []string{"a"}, TokenCount: ai.TokenCount{Total: 1}},
+		aitest.Reply{Results: []string{"b"}, TokenCount: ai.TokenCount{Total: 1}},
+	)
+	fake.SetConcurrency(1)
+	c, n, err := New("test").SetInputN(1).Execute(fake, []string{"1", "2"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 prompts; got %d", n)
+	}
+	var res []string
+	var tokens int64
+	for r := range c {
+		if r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		res = append(res, r.Result...)
+		tokens += r.Tokens
+	}
+	if expect := []string{"a", "b"}; !reflect.DeepEqual(res, expect) {
+		t.Errorf("expected %q; got %q", expect, res)
+	}
+	if tokens != 2 {
+		t.Errorf("expected 2 tokens; got %d", tokens)
+	}
+	if calls := fake.Calls(); len(calls) != 2 {
+		t.Errorf("expected 2 calls; got %d", len(calls))
+	}
+}
+
+func TestTokenBudget(t *testing.T) {
+	// The prompt without input takes 3 estimated tokens and each input line 2.
+	prompt := New("t").SetTokenBudget(7)
+	prompts, err := prompt.Prompts([]string{"aaaa", "bbbb", "cccc", "dddd", "eeee"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(prompts); n != 3 {
+		t.Fatalf("expected 3 prompts; got %d", n)
+	}
+	if expect := "t\nInput:\"\"\"\ncccc\ndddd\n\"\"\"\nOutput:"; prompts[1] != expect {
+		t.Errorf("expected %q; got %q", expect, prompts[1])
+	}
+	if prompts, err = prompt.SetInputN(1).Prompts([]string{"aaaa", "bbbb"}, ""); err != nil {
+		t.Fatal(err)
+	} else if n := len(prompts); n != 2 {
+		t.Errorf("expected 2 prompts; got %d", n)
+	}
+	if _, err := prompt.Prompts([]string{"aaaa", strings.Repeat("a", 100)}, ""); !errors.Is(err, ErrTokenBudget) {
+		t.Errorf("expected ErrTokenBudget; got %v", err)
+	}
+}
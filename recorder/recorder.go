@@ -0,0 +1,262 @@
+// Package recorder provides an http.RoundTripper which records HTTP interactions
+// to cassette files and replays them, so that tests can run offline.
+//
+// Use it with ai.WithTransport:
+//
+//	rec, err := recorder.New("testdata/cassettes/gemini.json", recorder.Replay, nil)
+//	...
+//	c, err := gemini.New(ctx, ai.WithAPIKey("test"), ai.WithTransport(rec))
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// Mode is the mode of a Recorder.
+type Mode int
+
+const (
+	// Replay serves requests from the cassette without any network access.
+	Replay Mode = iota
+	// Record sends requests through the underlying transport and records them.
+	Record
+)
+
+// SecretHeaders are the request and response headers which are scrubbed before saving.
+var SecretHeaders = []string{
+	"Authorization",
+	"Api-Key",
+	"X-Api-Key",
+	"X-Goog-Api-Key",
+	"Cookie",
+	"Set-Cookie",
+	"Openai-Organization",
+	"Openai-Project",
+}
+
+// SecretParams are the URL query parameters which are scrubbed before saving.
+var SecretParams = []string{"key", "api_key"}
+
+// Cassette is the content of a cassette file.
+type Cassette struct {
+	// Values holds arbitrary test settings, such as model names, recorded along with the interactions.
+	Values       map[string]string `json:"values,omitempty"`
+	Interactions []*Interaction    `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+var _ http.RoundTripper = new(Recorder)
+
+// Recorder records HTTP interactions to a cassette file or replays them from it.
+type Recorder struct {
+	mode      Mode
+	path      string
+	transport http.RoundTripper
+	secrets   []string
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// New returns a Recorder for the cassette file at path. In Replay mode the file must exist.
+// In Record mode, requests are sent through transport, or http.DefaultTransport if nil,
+// and the cassette is written by Save.
+func New(path string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	r := &Recorder{mode: mode, path: path, transport: transport}
+	if r.transport == nil {
+		r.transport = http.DefaultTransport
+	}
+	if mode == Replay {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &r.cassette); err != nil {
+			return nil, fmt.Errorf("recorder: %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	return r, nil
+}
+
+func (r *Recorder) Mode() Mode { return r.mode }
+
+// Scrub adds secrets, such as API keys, which are replaced in URLs and bodies before saving.
+func (r *Recorder) Scrub(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, i := range secrets {
+		if i != "" {
+			r.secrets = append(r.secrets, i)
+		}
+	}
+}
+
+// Value returns a value recorded in the cassette.
+func (r *Recorder) Value(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Values[key]
+}
+
+// SetValue records a value in the cassette.
+func (r *Recorder) SetValue(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cassette.Values == nil {
+		r.cassette.Values = make(map[string]string)
+	}
+	r.cassette.Values[key] = value
+}
+
+func (r *Recorder) scrub(s string) string {
+	for _, i := range r.secrets {
+		s = strings.ReplaceAll(s, i, redacted)
+	}
+	return s
+}
+
+func (r *Recorder) scrubURL(u *url.URL) string {
+	v := *u
+	u = &v
+	q := u.Query()
+	for _, i := range SecretParams {
+		if q.Has(i) {
+			q.Set(i, redacted)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return r.scrub(u.String())
+}
+
+func scrubHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, i := range SecretHeaders {
+		if header.Get(i) != "" {
+			header.Set(i, redacted)
+		}
+	}
+	return header
+}
+
+func readBody(body io.ReadCloser) ([]byte, error) {
+	if body == nil || body == http.NoBody {
+		return nil, nil
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	r.mu.Lock()
+	recorded := Request{Method: req.Method, URL: r.scrubURL(req.URL), Header: scrubHeader(req.Header), Body: r.scrub(string(body))}
+	r.mu.Unlock()
+	if r.mode == Replay {
+		return r.replay(req, recorded)
+	}
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request:  recorded,
+		Response: Response{StatusCode: resp.StatusCode, Header: scrubHeader(resp.Header), Body: r.scrub(string(b))},
+	})
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n, i := range r.cassette.Interactions {
+		if !r.used[n] && match(i.Request, recorded) {
+			r.used[n] = true
+			return &http.Response{
+				Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+				StatusCode:    i.Response.StatusCode,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        i.Response.Header.Clone(),
+				Body:          io.NopCloser(strings.NewReader(i.Response.Body)),
+				ContentLength: int64(len(i.Response.Body)),
+				Request:       req,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("recorder: no recorded interaction for %s %s", recorded.Method, recorded.URL)
+}
+
+func match(a, b Request) bool {
+	if a.Method != b.Method || a.URL != b.URL {
+		return false
+	}
+	if a.Body == b.Body {
+		return true
+	}
+	var x, y any
+	if json.Unmarshal([]byte(a.Body), &x) != nil || json.Unmarshal([]byte(b.Body), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// Save writes the cassette file in Record mode. It does nothing in Replay mode.
+func (r *Recorder) Save() error {
+	if r.mode != Record {
+		return nil
+	}
+	r.mu.Lock()
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(b, '\n'), 0o644)
+}
@@ -0,0 +1,104 @@
+package recorder
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sse = "data: {\"delta\":\"Hello\"}\n\ndata: {\"delta\":\" world\"}\n\ndata: [DONE]\n\n"
+
+func do(t *testing.T, client *http.Client, url, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestRecorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, i := range strings.SplitAfter(sse, "\n\n") {
+				io.WriteString(w, i)
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"echo":"secret"}`)
+	}))
+	path := filepath.Join(t.TempDir(), "cassettes", "test.json")
+
+	rec, err := New(path, Record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Scrub("secret")
+	rec.SetValue("model", "test-model")
+	client := &http.Client{Transport: rec}
+	if _, body := do(t, client, ts.URL+"/chat?key=secret", `{"a":1,"b":2}`); body != `{"echo":"secret"}` {
+		t.Errorf("got %q", body)
+	}
+	if _, body := do(t, client, ts.URL+"/stream", `{}`); body != sse {
+		t.Errorf("got %q", body)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Errorf("secret not scrubbed: %s", b)
+	}
+
+	if _, err := New(filepath.Join(t.TempDir(), "none.json"), Replay, nil); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error; got %v", err)
+	}
+	rec, err = New(path, Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := rec.Value("model"); v != "test-model" {
+		t.Errorf("expected test-model; got %q", v)
+	}
+	client = &http.Client{Transport: rec}
+	resp, body := do(t, client, ts.URL+"/stream", `{}`)
+	if body != sse {
+		t.Errorf("got %q", body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream; got %q", ct)
+	}
+	if _, body := do(t, client, ts.URL+"/chat?key=secret", `{"b":2, "a":1}`); body != `{"echo":"REDACTED"}` {
+		t.Errorf("got %q", body)
+	}
+	req, _ := http.NewRequest("POST", ts.URL+"/chat?key=secret", strings.NewReader(`{"a":1,"b":2}`))
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected error for used interaction")
+	}
+}
@@ -0,0 +1,329 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrorInfo describes an error returned by a provider API.
+type ErrorInfo struct {
+	StatusCode int
+	RetryAfter time.Duration
+	// QuotaExceeded is set when the quota of the account is used up,
+	// as opposed to a transient rate limit.
+	QuotaExceeded bool
+}
+
+// ErrorClassifier reports whether err is a provider API error and describes it.
+type ErrorClassifier func(err error) (ErrorInfo, bool)
+
+var (
+	classifierMu sync.RWMutex
+	classifiers  []ErrorClassifier
+)
+
+// RegisterErrorClassifier registers a classifier for the error type of a provider.
+// Providers call it in their init function.
+func RegisterErrorClassifier(classifier ErrorClassifier) {
+	if classifier == nil {
+		panic("ai: RegisterErrorClassifier classifier is nil")
+	}
+	classifierMu.Lock()
+	defer classifierMu.Unlock()
+	classifiers = append(classifiers, classifier)
+}
+
+// ClassifyError describes err using the registered classifiers.
+func ClassifyError(err error) (ErrorInfo, bool) {
+	classifierMu.RLock()
+	defer classifierMu.RUnlock()
+	for _, i := range classifiers {
+		if info, ok := i(err); ok {
+			return info, true
+		}
+	}
+	return ErrorInfo{}, false
+}
+
+// RetryAfter parses the Retry-After headers of a response.
+func RetryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	s := header.Get("Retry-After")
+	if s == "" {
+		return 0
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil && sec > 0 {
+		return time.Duration(sec * float64(time.Second))
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// IsRetryable reports whether the request that failed with err is worth retrying,
+// and how long the provider asked to wait before doing so.
+func IsRetryable(err error) (bool, time.Duration) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	if info, ok := ClassifyError(err); ok {
+		if info.QuotaExceeded {
+			return false, 0
+		}
+		switch code := info.StatusCode; {
+		case code == http.StatusRequestTimeout, code == http.StatusConflict, code == http.StatusTooManyRequests:
+		case code >= 500:
+		default:
+			return false, 0
+		}
+		return true, info.RetryAfter
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+	return false, 0
+}
+
+const (
+	DefaultMaxRetries = 3
+	DefaultBaseDelay  = 500 * time.Millisecond
+	DefaultMaxDelay   = 30 * time.Second
+)
+
+// RetryPolicy configures how failed requests are retried.
+// Zero values use the defaults.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries; a negative value disables retries.
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// Classify overrides IsRetryable.
+	Classify func(error) (bool, time.Duration)
+}
+
+func (p RetryPolicy) maxRetries() int {
+	if p.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+	return max(p.MaxRetries, 0)
+}
+
+func (p RetryPolicy) classify(err error) (bool, time.Duration) {
+	if p.Classify != nil {
+		return p.Classify(err)
+	}
+	return IsRetryable(err)
+}
+
+// Backoff returns the delay before the given retry, starting from 0,
+// using exponential backoff with jitter.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	d := maxDelay
+	if attempt < 32 {
+		d = min(base<<attempt, maxDelay)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// wait sleeps before the given retry if err is retryable.
+func (p RetryPolicy) wait(ctx context.Context, attempt int, err error) bool {
+	if attempt >= p.maxRetries() || ctx.Err() != nil {
+		return false
+	}
+	retry, after := p.classify(err)
+	if !retry {
+		return false
+	}
+	if after <= 0 {
+		after = p.Backoff(attempt)
+	}
+	t := time.NewTimer(after)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !p.wait(ctx, attempt, err) {
+			return err
+		}
+	}
+}
+
+// Middleware returns a middleware which retries failed chat calls according to the policy.
+// Streams are retried only if no chunk has been delivered yet.
+func (p RetryPolicy) Middleware() Middleware {
+	return func(next ChatFunc) ChatFunc {
+		return func(ctx context.Context, req *Request) (res *Result, err error) {
+			restore := func() {}
+			call := func() (*Result, error) {
+				if req.Session != nil {
+					restore = restoreHistory(req.Session)
+				}
+				res, err := next(ctx, req)
+				if err != nil {
+					restore()
+				}
+				return res, err
+			}
+			if !req.Stream {
+				err = p.do(ctx, func() (err error) {
+					res, err = call()
+					return
+				})
+				return
+			}
+			s := &retryStream{ctx: ctx, policy: p, restore: func() { restore() }, open: func() (ChatStream, error) {
+				res, err := call()
+				if err != nil {
+					return nil, err
+				}
+				return res.Stream, nil
+			}}
+			if err = s.reopen(); err != nil {
+				return nil, err
+			}
+			return &Result{Stream: s}, nil
+		}
+	}
+}
+
+// HistorySnapshotter is implemented by chat sessions which can restore their history
+// without converting it to Content, keeping provider data such as thinking blocks.
+type HistorySnapshotter interface {
+	// SnapshotHistory returns a function which restores the history to its current state.
+	SnapshotHistory() (restore func())
+}
+
+// restoreHistory returns a function which rolls the session history back to its current state
+// if a failed call has changed it. Sessions which are not HistorySnapshotter are restored
+// through SetHistory.
+func restoreHistory(session ChatSession) func() {
+	if s, ok := session.(HistorySnapshotter); ok {
+		return s.SnapshotHistory()
+	}
+	history := session.History()
+	return func() {
+		if len(session.History()) != len(history) {
+			session.SetHistory(history)
+		}
+	}
+}
+
+// Retry returns an AI which retries failed requests of ai according to policy.
+func Retry(ai AI, policy RetryPolicy) AI {
+	retry := &retryAI{Wrap(ai, policy.Middleware()), policy}
+	if e, ok := ai.(Embedder); ok {
+		return &embedderAI{retry, &retryEmbedder{e, policy}}
+	}
+	return retry
+}
+
+type retryAI struct {
+	AI
+	policy RetryPolicy
+}
+
+func (ai *retryAI) ListModels(ctx context.Context) (models []string, err error) {
+	err = ai.policy.do(ctx, func() (err error) {
+		models, err = ai.AI.ListModels(ctx)
+		return
+	})
+	return
+}
+
+func (ai *retryAI) CountTokens(ctx context.Context, parts ...Part) (n int64, err error) {
+	err = ai.policy.do(ctx, func() (err error) {
+		n, err = ai.AI.CountTokens(ctx, parts...)
+		return
+	})
+	return
+}
+
+type retryEmbedder struct {
+	Embedder
+	policy RetryPolicy
+}
+
+func (e *retryEmbedder) Embed(ctx context.Context, texts ...string) (vectors [][]float32, usage TokenCount, err error) {
+	err = e.policy.do(ctx, func() (err error) {
+		vectors, usage, err = e.Embedder.Embed(ctx, texts...)
+		return
+	})
+	return
+}
+
+type retryStream struct {
+	ChatStream
+	ctx       context.Context
+	policy    RetryPolicy
+	open      func() (ChatStream, error)
+	restore   func()
+	attempt   int
+	delivered bool
+}
+
+func (s *retryStream) reopen() (err error) {
+	for ; ; s.attempt++ {
+		if s.ChatStream, err = s.open(); err == nil || !s.policy.wait(s.ctx, s.attempt, err) {
+			return
+		}
+	}
+}
+
+func (s *retryStream) Close() error {
+	if s.ChatStream == nil {
+		return nil
+	}
+	return s.ChatStream.Close()
+}
+
+func (s *retryStream) Next() (ChatResponse, error) {
+	for {
+		resp, err := s.ChatStream.Next()
+		if err == nil || err == io.EOF || s.delivered {
+			s.delivered = true
+			return resp, err
+		}
+		s.Close()
+		s.restore()
+		if !s.policy.wait(s.ctx, s.attempt, err) {
+			return nil, err
+		}
+		s.attempt++
+		if err := s.reopen(); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+type flakyAI struct {
+	AI
+	errs  []error
+	calls int
+}
+
+func (ai *flakyAI) err() error {
+	ai.calls++
+	if len(ai.errs) == 0 {
+		return nil
+	}
+	err := ai.errs[0]
+	ai.errs = ai.errs[1:]
+	return err
+}
+
+func (ai *flakyAI) Chat(context.Context, ...Part) (ChatResponse, error) {
+	if err := ai.err(); err != nil {
+		return nil, err
+	}
+	return testResponse{results: []string{"ok"}}, nil
+}
+
+func (ai *flakyAI) ChatStream(context.Context, ...Part) (ChatStream, error) {
+	return &flakyStream{ai.err()}, nil
+}
+
+type flakyStream struct{ err error }
+
+func (s *flakyStream) Next() (ChatResponse, error) {
+	if err := s.err; err != nil {
+		s.err = io.EOF
+		return nil, err
+	}
+	s.err = errTransient
+	return testResponse{results: []string{"ok"}}, nil
+}
+func (*flakyStream) Close() error { return nil }
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		Classify:   func(err error) (bool, time.Duration) { return err == errTransient, 0 },
+	}
+	c := &flakyAI{errs: []error{errTransient, errTransient}}
+	if _, err := Retry(c, policy).Chat(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.calls != 3 {
+		t.Errorf("expected 3 calls; got %d", c.calls)
+	}
+	c = &flakyAI{errs: []error{errTransient, errTransient, errTransient}}
+	if _, err := Retry(c, policy).Chat(context.Background()); err != errTransient {
+		t.Errorf("expected errTransient; got %v", err)
+	}
+	c = &flakyAI{errs: []error{io.ErrClosedPipe, nil}}
+	if _, err := Retry(c, policy).Chat(context.Background()); err != io.ErrClosedPipe || c.calls != 1 {
+		t.Errorf("expected no retry; got %v after %d calls", err, c.calls)
+	}
+
+	c = &flakyAI{errs: []error{errTransient}}
+	stream, err := Retry(c, policy).ChatStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if _, err := stream.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Next(); err != errTransient {
+		t.Errorf("expected errTransient after first chunk; got %v", err)
+	}
+	if c.calls != 2 {
+		t.Errorf("expected 2 calls; got %d", c.calls)
+	}
+}
+
+type flakySession struct {
+	ai         *flakyAI
+	history    []string
+	setHistory bool
+}
+
+func (ai *flakyAI) ChatSession() ChatSession { return &flakySession{ai: ai} }
+
+func (s *flakySession) Chat(context.Context, ...Part) (ChatResponse, error) {
+	s.history = append(s.history, "user")
+	if err := s.ai.err(); err != nil {
+		return nil, err
+	}
+	s.history = append(s.history, "model")
+	return testResponse{results: []string{"ok"}}, nil
+}
+func (s *flakySession) ChatStream(context.Context, ...Part) (ChatStream, error) { return nil, nil }
+func (s *flakySession) History() (history []Content) {
+	for _, i := range s.history {
+		history = append(history, Content{Role: i})
+	}
+	return
+}
+func (s *flakySession) SetHistory([]Content) error { s.setHistory = true; return nil }
+func (s *flakySession) SnapshotHistory() func() {
+	history := slices.Clone(s.history)
+	return func() { s.history = history }
+}
+
+func TestRetrySession(t *testing.T) {
+	policy := RetryPolicy{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		Classify:   func(err error) (bool, time.Duration) { return err == errTransient, 0 },
+	}
+	c := &flakyAI{errs: []error{errTransient, errTransient}}
+	session := Retry(c, policy).ChatSession()
+	if _, err := session.Chat(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := session.(*wrappedSession).ChatSession.(*flakySession)
+	if expect := []string{"user", "model"}; !slices.Equal(s.history, expect) {
+		t.Errorf("expected %q; got %q", expect, s.history)
+	}
+	if s.setHistory {
+		t.Error("expected history restored from snapshot; got SetHistory")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		header http.Header
+		expect time.Duration
+	}{
+		{nil, 0},
+		{http.Header{"Retry-After": {"2"}}, 2 * time.Second},
+		{http.Header{"Retry-After": {"2"}, "Retry-After-Ms": {"1500"}}, 1500 * time.Millisecond},
+		{http.Header{"Retry-After": {"bad"}}, 0},
+	} {
+		if d := RetryAfter(tc.header); d != tc.expect {
+			t.Errorf("%v: expected %s; got %s", tc.header, tc.expect, d)
+		}
+	}
+}
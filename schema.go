@@ -0,0 +1,256 @@
+package ai
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Schema is a JSON Schema.
+type Schema struct {
+	Type        string `json:"type,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Nullable    bool   `json:"nullable,omitempty"`
+	Default     any    `json:"default,omitempty"`
+
+	Enum    []string  `json:"enum,omitempty"`
+	AnyOf   []*Schema `json:"anyOf,omitempty"`
+	Minimum *float64  `json:"minimum,omitempty"`
+	Maximum *float64  `json:"maximum,omitempty"`
+
+	MinLength *int64 `json:"minLength,omitempty"`
+	MaxLength *int64 `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+
+	Items    *Schema `json:"items,omitempty"`
+	MinItems *int64  `json:"minItems,omitempty"`
+	MaxItems *int64  `json:"maxItems,omitempty"`
+
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	// AdditionalProperties is either a bool or a *Schema.
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+
+	Ref  string             `json:"$ref,omitempty"`
+	Defs map[string]*Schema `json:"$defs,omitempty"`
+}
+
+var (
+	_ json.Marshaler   = Schema{}
+	_ json.Unmarshaler = new(Schema)
+)
+
+// MarshalJSON encodes the schema as standard JSON Schema,
+// where Nullable is expressed by adding "null" to the type.
+func (s Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	v := struct {
+		Type any `json:"type,omitempty"`
+		schema
+		Nullable             bool `json:"nullable,omitempty"`
+		AdditionalProperties any  `json:"additionalProperties,omitempty"`
+	}{schema: schema(s)}
+	if s.Type != "" {
+		v.Type = s.Type
+	}
+	if s.Nullable {
+		if s.Type != "" {
+			v.Type = []string{s.Type, "null"}
+		} else if len(s.AnyOf) > 0 {
+			v.AnyOf = append(s.AnyOf[:len(s.AnyOf):len(s.AnyOf)], &Schema{Type: "null"})
+		} else {
+			return nil, errors.New("ai: nullable schema without type or anyOf")
+		}
+	}
+	switch ap := s.AdditionalProperties.(type) {
+	case nil:
+	case bool:
+		v.AdditionalProperties = ap
+	case *Schema:
+		if ap != nil {
+			v.AdditionalProperties = ap
+		}
+	case Schema:
+		v.AdditionalProperties = &ap
+	default:
+		return nil, fmt.Errorf("ai: unsupported additionalProperties type %T", ap)
+	}
+	return json.Marshal(v)
+}
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	type schema Schema
+	var v struct {
+		Type json.RawMessage `json:"type"`
+		schema
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = Schema(v.schema)
+	if len(v.Type) > 0 {
+		var types []string
+		if err := json.Unmarshal(v.Type, &s.Type); err != nil {
+			if err := json.Unmarshal(v.Type, &types); err != nil {
+				return err
+			}
+			s.Type = ""
+			for _, i := range types {
+				if i == "null" {
+					s.Nullable = true
+				} else if s.Type == "" {
+					s.Type = i
+				} else {
+					return errors.New("ai: unsupported multiple schema types: " + string(v.Type))
+				}
+			}
+			if s.Type == "" && s.Nullable {
+				s.Type, s.Nullable = "null", false
+			}
+		}
+	}
+	if len(v.AdditionalProperties) > 0 {
+		var b bool
+		if err := json.Unmarshal(v.AdditionalProperties, &b); err == nil {
+			s.AdditionalProperties = b
+		} else {
+			ap := new(Schema)
+			if err := json.Unmarshal(v.AdditionalProperties, ap); err != nil {
+				return err
+			}
+			s.AdditionalProperties = ap
+		}
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// SchemaFor derives a Schema from the type T.
+//
+// Struct fields are named by their json tags and may be annotated with
+// description, enum (comma separated) and required tags. A field is required
+// unless its json tag has omitempty or it is tagged required:"false".
+// SchemaFor panics if T contains a type that cannot be described,
+// such as a channel, a function or a recursive type.
+func SchemaFor[T any]() Schema {
+	return *schemaFor(reflect.TypeFor[T](), nil)
+}
+
+// FunctionFor returns a Function whose parameters are derived from Args.
+func FunctionFor[Args any](name, description string) Function {
+	return Function{Name: name, Description: description, Parameters: SchemaFor[Args]()}
+}
+
+// DecodeArguments unmarshals the arguments of a function call into T.
+func DecodeArguments[T any](call FunctionCall) (v T, err error) {
+	if err = json.Unmarshal([]byte(call.Arguments), &v); err != nil {
+		err = fmt.Errorf("decode arguments of %q: %w", call.Name, err)
+	}
+	return
+}
+
+// DecodeResult unmarshals the first result of a JSON response into T.
+func DecodeResult[T any](resp ChatResponse) (v T, err error) {
+	res := resp.Results()
+	if len(res) == 0 {
+		return v, errors.New("no result")
+	}
+	err = json.Unmarshal([]byte(res[0]), &v)
+	return
+}
+
+func schemaFor(t reflect.Type, seen []reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for _, i := range seen {
+		if i == t {
+			panic("ai: SchemaFor: recursive type " + t.String())
+		}
+	}
+	if t.PkgPath() == "time" && t.Name() == "Time" {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"}
+		}
+		return &Schema{Type: "array", Items: schemaFor(t.Elem(), seen)}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			panic("ai: SchemaFor: unsupported map key type " + t.Key().String())
+		}
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), seen)}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		addFields(s, t, append(seen, t))
+		return s
+	default:
+		panic("ai: SchemaFor: unsupported type " + t.String())
+	}
+}
+
+func addFields(s *Schema, t reflect.Type, seen []reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(s, ft, seen)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		p := schemaFor(f.Type, seen)
+		p.Description = f.Tag.Get("description")
+		if enum := f.Tag.Get("enum"); enum != "" {
+			p.Enum = strings.Split(enum, ",")
+		}
+		s.Properties[name] = p
+		switch f.Tag.Get("required") {
+		case "true":
+		case "false":
+			continue
+		default:
+			if strings.Contains(","+opts+",", ",omitempty,") {
+				continue
+			}
+		}
+		s.Required = append(s.Required, name)
+	}
+}
@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchemaFor(t *testing.T) {
+	type Address struct {
+		City string `json:"city" description:"The city name"`
+	}
+	type Base struct {
+		ID int64 `json:"id"`
+	}
+	type Args struct {
+		Base
+		Name    string    `json:"name" description:"The name"`
+		Unit    string    `json:"unit,omitempty" enum:"celsius,fahrenheit"`
+		Tags    []string  `json:"tags" required:"false"`
+		Score   *float64  `json:"score,omitempty" required:"true"`
+		Address *Address  `json:"address"`
+		Time    time.Time `json:"time,omitempty"`
+		Ignored string    `json:"-"`
+		private string
+	}
+	expect := Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"id":    {Type: "integer"},
+			"name":  {Type: "string", Description: "The name"},
+			"unit":  {Type: "string", Enum: []string{"celsius", "fahrenheit"}},
+			"tags":  {Type: "array", Items: &Schema{Type: "string"}},
+			"score": {Type: "number"},
+			"address": {
+				Type:       "object",
+				Properties: map[string]*Schema{"city": {Type: "string", Description: "The city name"}},
+				Required:   []string{"city"},
+			},
+			"time": {Type: "string", Format: "date-time"},
+		},
+		Required: []string{"id", "name", "score", "address"},
+	}
+	if s := SchemaFor[Args](); !reflect.DeepEqual(s, expect) {
+		t.Errorf("expected %#v; got %#v", expect, s)
+	}
+
+	type Node struct {
+		Next *Node `json:"next"`
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic on recursive type")
+			}
+		}()
+		SchemaFor[Node]()
+	}()
+}
+
+func TestDecodeArguments(t *testing.T) {
+	type Args struct {
+		Location string `json:"location"`
+	}
+	f := FunctionFor[Args]("find_theaters", "find theaters")
+	if b, _ := json.Marshal(f.Parameters); string(b) != `{"type":"object","properties":{"location":{"type":"string"}},"required":["location"]}` {
+		t.Errorf("unexpected parameters: %s", b)
+	}
+	args, err := DecodeArguments[Args](FunctionCall{Name: f.Name, Arguments: `{"location":"Mountain View"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Location != "Mountain View" {
+		t.Errorf("expected Mountain View; got %q", args.Location)
+	}
+	if _, err := DecodeArguments[Args](FunctionCall{Name: f.Name, Arguments: "bad"}); err == nil {
+		t.Error("expected error; got nil")
+	}
+}
+
+func TestSchemaJSON(t *testing.T) {
+	maxLength := int64(10)
+	s := Schema{
+		Type:     "object",
+		Nullable: true,
+		Properties: map[string]*Schema{
+			"name": {Type: "string", Nullable: true, MaxLength: &maxLength},
+			"tags": {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+		},
+		AdditionalProperties: false,
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := `{"type":["object","null"],"properties":{"name":{"type":["string","null"],"maxLength":10},"tags":{"type":"object","additionalProperties":{"type":"string"}}},"additionalProperties":false}`; string(b) != expect {
+		t.Errorf("expected %s; got %s", expect, b)
+	}
+	var v Schema
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, v) {
+		t.Errorf("expected %#v; got %#v", s, v)
+	}
+	if _, err := json.Marshal(Schema{AdditionalProperties: 1}); err == nil {
+		t.Error("expected error; got nil")
+	}
+	if _, err := json.Marshal(Schema{Nullable: true}); err == nil {
+		t.Error("expected error for nullable schema without type; got nil")
+	}
+	if b, err := json.Marshal(Schema{Nullable: true, AnyOf: []*Schema{{Type: "string"}}}); err != nil {
+		t.Fatal(err)
+	} else if expect := `{"anyOf":[{"type":"string"},{"type":"null"}]}`; string(b) != expect {
+		t.Errorf("expected %s; got %s", expect, b)
+	}
+	if err := json.Unmarshal([]byte(`{"type":["null"]}`), &v); err != nil {
+		t.Fatal(err)
+	} else if v.Type != "null" || v.Nullable {
+		t.Errorf("expected null type; got %#v", v)
+	}
+}
@@ -0,0 +1,46 @@
+package ai
+
+import "unicode/utf8"
+
+// imageTokens is the estimated number of tokens of an image.
+const imageTokens = 765
+
+// EstimateTokens estimates the number of tokens of parts without calling any API,
+// assuming about four characters per token for ASCII text and one token per other character.
+func EstimateTokens(parts ...Part) (n int64) {
+	for _, i := range parts {
+		switch v := i.(type) {
+		case Text:
+			n += estimateText(string(v))
+		case Image, Blob:
+			n += imageTokens
+		case FunctionCall:
+			n += estimateText(v.Name) + estimateText(v.Arguments)
+		case FunctionResponse:
+			n += estimateText(v.Response)
+		case Thought:
+			n += estimateText(v.Text)
+		}
+	}
+	return
+}
+
+// EstimateHistory estimates the number of tokens of history with EstimateTokens.
+func EstimateHistory(history []Content) (n int64) {
+	for _, i := range history {
+		n += EstimateTokens(i.Parts...)
+	}
+	return
+}
+
+func estimateText(s string) int64 {
+	var ascii, other int64
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultMaxToolIterations is the default number of model turns RunTools allows.
+var DefaultMaxToolIterations = 10
+
+var ErrMaxToolIterations = errors.New("tool calling exceeded max iterations")
+
+// ToolHandler handles a function call requested by the model.
+// The returned string is sent back to the model as the function response.
+type ToolHandler func(ctx context.Context, call FunctionCall) (string, error)
+
+// ToolStep records one model turn and the function responses sent back for it.
+type ToolStep struct {
+	Response  ChatResponse
+	Calls     []FunctionCall
+	Responses []FunctionResponse
+	Errors    []error
+}
+
+type ToolResult struct {
+	Response ChatResponse
+	Steps    []ToolStep
+}
+
+type ToolRunner struct {
+	Handlers      map[string]ToolHandler
+	MaxIterations int
+}
+
+// RunTools sends parts to session and dispatches function calls to handlers until
+// the model replies without function calls.
+func RunTools(ctx context.Context, session ChatSession, handlers map[string]ToolHandler, parts ...Part) (*ToolResult, error) {
+	return (&ToolRunner{Handlers: handlers}).Run(ctx, session, parts...)
+}
+
+func (r *ToolRunner) Run(ctx context.Context, session ChatSession, parts ...Part) (*ToolResult, error) {
+	max := r.MaxIterations
+	if max <= 0 {
+		max = DefaultMaxToolIterations
+	}
+	res := new(ToolResult)
+	for range max {
+		resp, err := session.Chat(ctx, parts...)
+		if err != nil {
+			return res, err
+		}
+		res.Response = resp
+		calls := resp.FunctionCalls()
+		if len(calls) == 0 {
+			return res, nil
+		}
+		step := ToolStep{
+			Response:  resp,
+			Calls:     calls,
+			Responses: make([]FunctionResponse, len(calls)),
+			Errors:    make([]error, len(calls)),
+		}
+		var wg sync.WaitGroup
+		for i, call := range calls {
+			wg.Go(func() {
+				step.Responses[i], step.Errors[i] = r.call(ctx, call)
+			})
+		}
+		wg.Wait()
+		res.Steps = append(res.Steps, step)
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		parts = parts[:0:0]
+		for _, i := range step.Responses {
+			parts = append(parts, i)
+		}
+	}
+	return res, ErrMaxToolIterations
+}
+
+func (r *ToolRunner) call(ctx context.Context, call FunctionCall) (resp FunctionResponse, err error) {
+	resp.ID, resp.Name = call.ID, call.Name
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("tool %q panicked: %v", call.Name, v)
+		}
+		if err != nil {
+			resp.Response = toolResponse("", err)
+		}
+	}()
+	handler, ok := r.Handlers[call.Name]
+	if !ok {
+		return resp, fmt.Errorf("unknown tool %q", call.Name)
+	}
+	s, err := handler(ctx, call)
+	if err != nil {
+		return resp, err
+	}
+	resp.Response = toolResponse(s, nil)
+	return
+}
+
+// toolResponse makes sure the response is a JSON object, which some providers require.
+func toolResponse(s string, err error) string {
+	if err != nil {
+		b, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return string(b)
+	}
+	var m map[string]any
+	if json.Unmarshal([]byte(s), &m) == nil {
+		return s
+	}
+	var v any = s
+	if json.Valid([]byte(s)) {
+		v = json.RawMessage(s)
+	}
+	b, _ := json.Marshal(map[string]any{"result": v})
+	return string(b)
+}
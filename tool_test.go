@@ -0,0 +1,80 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testResponse struct {
+	results []string
+	calls   []FunctionCall
+}
+
+func (resp testResponse) Raw() any                      { return nil }
+func (resp testResponse) Results() []string             { return resp.results }
+func (testResponse) Thoughts() []string                 { return nil }
+func (resp testResponse) FunctionCalls() []FunctionCall { return resp.calls }
+func (testResponse) TokenCount() TokenCount             { return TokenCount{} }
+
+type testSession struct {
+	replies []testResponse
+	sent    [][]Part
+}
+
+func (s *testSession) Chat(_ context.Context, parts ...Part) (ChatResponse, error) {
+	s.sent = append(s.sent, parts)
+	if len(s.replies) == 0 {
+		return nil, errors.New("no reply")
+	}
+	resp := s.replies[0]
+	s.replies = s.replies[1:]
+	return resp, nil
+}
+func (s *testSession) ChatStream(context.Context, ...Part) (ChatStream, error) { return nil, nil }
+func (s *testSession) History() []Content                                      { return nil }
+func (s *testSession) SetHistory([]Content) error                              { return nil }
+
+func TestRunTools(t *testing.T) {
+	session := &testSession{replies: []testResponse{
+		{calls: []FunctionCall{
+			{ID: "1", Name: "add", Arguments: `{"a":1,"b":2}`},
+			{ID: "2", Name: "fail"},
+			{ID: "3", Name: "unknown"},
+		}},
+		{results: []string{"done"}},
+	}}
+	res, err := RunTools(context.Background(), session, map[string]ToolHandler{
+		"add":  func(context.Context, FunctionCall) (string, error) { return "3", nil },
+		"fail": func(context.Context, FunctionCall) (string, error) { return "", errors.New("failed") },
+	}, Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := res.Response.Results(); !reflect.DeepEqual(res, []string{"done"}) {
+		t.Errorf("expected done; got %q", res)
+	}
+	if n := len(res.Steps); n != 1 {
+		t.Fatalf("expected 1 step; got %d", n)
+	}
+	if expect := []Part{
+		FunctionResponse{ID: "1", Name: "add", Response: `{"result":3}`},
+		FunctionResponse{ID: "2", Name: "fail", Response: `{"error":"failed"}`},
+		FunctionResponse{ID: "3", Name: "unknown", Response: `{"error":"unknown tool \"unknown\""}`},
+	}; !reflect.DeepEqual(session.sent[1], expect) {
+		t.Errorf("expected %v; got %v", expect, session.sent[1])
+	}
+	if err := res.Steps[0].Errors[1]; err == nil {
+		t.Error("expected error; got nil")
+	}
+
+	loop := testResponse{calls: []FunctionCall{{ID: "1", Name: "add"}}}
+	session = &testSession{replies: []testResponse{loop, loop, loop}}
+	if _, err := (&ToolRunner{
+		Handlers:      map[string]ToolHandler{"add": func(context.Context, FunctionCall) (string, error) { return "{}", nil }},
+		MaxIterations: 2,
+	}).Run(context.Background(), session, Text("hello")); err != ErrMaxToolIterations {
+		t.Errorf("expected ErrMaxToolIterations; got %v", err)
+	}
+}